@@ -0,0 +1,285 @@
+package unexport
+
+import (
+	"fmt"
+	"go/build"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types/objectpath"
+)
+
+// Analyzer reports the exported identifiers not used outside of their package,
+// with their renaming as suggested fix.
+//
+// The analysis is modular, each package of the main module exports a fact of
+// the identifiers of the other packages used by it and by its dependencies,
+// combining their facts, and of its own exported identifiers, checked for
+// conflicts as the Unexporter does. The identifiers are reported by the root
+// packages, the commands by default, if none of the packages they depend on
+// uses them, so an identifier used by another root only is reported too, see
+// -roots. Unlike the unexport command, the uses from the tests of the other
+// packages are not known. The renaming is a suggested fix if it only edits the
+// files of the reporting package, the drivers don't apply the edits of the
+// other packages.
+var Analyzer = &analysis.Analyzer{
+	Name:      "unexport",
+	Doc:       "report exported identifiers not used outside of their package",
+	URL:       "https://github.com/isaiah/unexport",
+	Run:       runAnalyzer,
+	FactTypes: []analysis.Fact{new(packageFact)},
+}
+
+var analyzerRoots string
+
+func init() {
+	Analyzer.Flags.StringVar(&analyzerRoots, "roots", "",
+		"comma-separated import paths or patterns such as foo/... of the packages reporting the unused identifiers of their dependencies, the commands by default")
+}
+
+// packageFact describes the uses of a package and of its dependencies, and
+// its exported identifiers not used within it
+type packageFact struct {
+	Uses       []string // objectKey of the objects of the other packages
+	Candidates []candidate
+}
+
+func (*packageFact) AFact() {}
+
+func (f *packageFact) String() string {
+	return fmt.Sprintf("uses %d objects, %d candidates", len(f.Uses), len(f.Candidates))
+}
+
+// candidate is an exported identifier and its renaming
+type candidate struct {
+	Path      objectpath.Path
+	Qualifier string
+	Kind      string
+	To        string
+	Conflicts []Conflict
+	Edits     []textEdit
+}
+
+// textEdit replaces an identifier, the line and column check the position
+// is found in a file of the package, see analyzerPositions
+type textEdit struct {
+	Filename             string
+	Offset, Line, Column int
+	Old, New             string
+}
+
+// objectKey identifies an object across packages
+func objectKey(pkgPath string, path objectpath.Path) string {
+	return pkgPath + " " + string(path)
+}
+
+func runAnalyzer(pass *analysis.Pass) (interface{}, error) {
+	// the packages of the standard library and of the other modules
+	// don't use the main module
+	if pass.Module != nil && pass.Module.Version != "" || inGoroot(pass) {
+		return nil, nil
+	}
+	fact := analyzePackage(pass)
+	// the uses of the dependencies are combined, each fact has the uses
+	// of the whole dependency graph of its package
+	facts := []analysis.PackageFact{{Package: pass.Pkg, Fact: fact}}
+	uses := make(map[string]bool)
+	for _, key := range fact.Uses {
+		uses[key] = true
+	}
+	for _, f := range pass.AllPackageFacts() {
+		if f.Package == pass.Pkg {
+			continue
+		}
+		facts = append(facts, f)
+		for _, key := range f.Fact.(*packageFact).Uses {
+			uses[key] = true
+		}
+	}
+	fact.Uses = fact.Uses[:0]
+	for key := range uses {
+		fact.Uses = append(fact.Uses, key)
+	}
+	sort.Strings(fact.Uses)
+	pass.ExportPackageFact(fact)
+	if !isAnalyzerRoot(pass.Pkg) {
+		return nil, nil
+	}
+
+	files := make(map[string]bool)
+	for _, f := range pass.Files {
+		files[pass.Fset.File(f.Pos()).Name()] = true
+	}
+	// the fixes only edit the files of the package, the related
+	// information is in any file parsed
+	edits, related := analyzerPositions(pass.Fset, files), analyzerPositions(pass.Fset, nil)
+	reported := make(map[string]bool)
+	for _, f := range facts {
+		for _, c := range f.Fact.(*packageFact).Candidates {
+			key := objectKey(f.Package.Path(), c.Path)
+			if uses[key] || reported[key] {
+				continue
+			}
+			reported[key] = true
+			obj, err := objectpath.Object(f.Package, c.Path)
+			if err != nil || !obj.Pos().IsValid() {
+				continue
+			}
+			pass.Report(c.diagnostic(obj.Pos(), edits, related))
+		}
+	}
+	return nil, nil
+}
+
+// isAnalyzerRoot reports whether the package reports the unused identifiers, see Analyzer
+func isAnalyzerRoot(pkg *types.Package) bool {
+	if analyzerRoots == "" {
+		// the test main package is generated
+		return pkg.Name() == "main" && !strings.HasSuffix(pkg.Path(), ".test")
+	}
+	for _, pattern := range strings.Split(analyzerRoots, ",") {
+		if prefix := strings.TrimSuffix(pattern, "/..."); pkg.Path() == pattern ||
+			prefix != pattern && (pkg.Path() == prefix || strings.HasPrefix(pkg.Path(), prefix+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// analyzePackage finds the objects of the other packages used by the
+// package, and checks the renaming of its exported identifiers
+func analyzePackage(pass *analysis.Pass) *packageFact {
+	info := &loader.PackageInfo{
+		Pkg:                   pass.Pkg,
+		Importable:            true,
+		TransitivelyErrorFree: true,
+		Files:                 pass.Files,
+		Info:                  *pass.TypesInfo,
+	}
+	prog := &loader.Program{
+		Fset:        pass.Fset,
+		Imported:    map[string]*loader.PackageInfo{pass.Pkg.Path(): info},
+		AllPackages: map[*types.Package]*loader.PackageInfo{pass.Pkg: info},
+	}
+	u := newUnexporter(&Config{}, prog, []string{pass.Pkg.Path()})
+
+	fact := &packageFact{}
+	for obj := range u.usedObjects() {
+		if obj.Pkg() == nil || obj.Pkg() == pass.Pkg {
+			continue
+		}
+		if path, err := objectpath.For(obj); err == nil {
+			fact.Uses = append(fact.Uses, objectKey(obj.Pkg().Path(), path))
+		}
+	}
+	sort.Strings(fact.Uses)
+	for _, obj := range u.UnusedObjectsSorted() {
+		path, err := objectpath.For(obj)
+		if err != nil {
+			continue
+		}
+		info := u.Identifiers[obj]
+		c := candidate{
+			Path:      path,
+			Qualifier: u.Qualifier(obj),
+			Kind:      objectKind(obj),
+			To:        info.objsToUpdate[obj],
+			Conflicts: info.Conflicts,
+		}
+		for _, e := range u.edits(obj) {
+			for _, pos := range e.Occurrences {
+				c.Edits = append(c.Edits, textEdit{
+					Filename: pos.Filename,
+					Offset:   pos.Offset,
+					Line:     pos.Line,
+					Column:   pos.Column,
+					Old:      e.From,
+					New:      e.To,
+				})
+			}
+		}
+		fact.Candidates = append(fact.Candidates, c)
+	}
+	return fact
+}
+
+func inGoroot(pass *analysis.Pass) bool {
+	if len(pass.Files) == 0 {
+		return true
+	}
+	filename := pass.Fset.File(pass.Files[0].Pos()).Name()
+	goroot := filepath.Join(build.Default.GOROOT, "src") + string(filepath.Separator)
+	return strings.HasPrefix(filename, goroot)
+}
+
+// analyzerPosition returns the position of an offset of a file parsed in the
+// file set, the files of the packages imported from export data are not
+// parsed, their lines are only approximated, the line and column check the
+// file is the one that was analyzed. The line and column are adjusted by the
+// //line directives if adjusted is set.
+type analyzerPosition func(filename string, offset, line, column int, adjusted bool) token.Pos
+
+// analyzerPositions finds the positions in the files named, or in any file if
+// filenames is nil
+func analyzerPositions(fset *token.FileSet, filenames map[string]bool) analyzerPosition {
+	files := make(map[string]*token.File)
+	fset.Iterate(func(f *token.File) bool {
+		if filenames == nil || filenames[f.Name()] {
+			files[f.Name()] = f
+		}
+		return true
+	})
+	return func(filename string, offset, line, column int, adjusted bool) token.Pos {
+		f := files[filename]
+		if f == nil || offset > f.Size() {
+			return token.NoPos
+		}
+		pos := f.Pos(offset)
+		if p := fset.PositionFor(pos, adjusted); p.Line != line || p.Column != column {
+			return token.NoPos
+		}
+		return pos
+	}
+}
+
+// diagnostic reports the candidate declared at pos, the renaming is a fix
+// if it has no conflicts and all the edits are found
+func (c candidate) diagnostic(pos token.Pos, edit, related analyzerPosition) analysis.Diagnostic {
+	d := analysis.Diagnostic{
+		Pos:      pos,
+		Category: c.Kind,
+		Message:  fmt.Sprintf("%s %s is exported but not used outside of its package", c.Kind, c.Qualifier),
+	}
+	if len(c.Conflicts) > 0 {
+		d.Message += fmt.Sprintf(", renaming it to %s causes conflicts", c.To)
+		for _, conflict := range c.Conflicts {
+			p := related(conflict.Pos.Filename, conflict.Pos.Offset, conflict.Pos.Line, conflict.Pos.Column, true)
+			if p.IsValid() {
+				d.Related = append(d.Related, analysis.RelatedInformation{
+					Pos:     p,
+					Message: strings.TrimPrefix(conflict.Message, "\t"),
+				})
+			}
+		}
+		return d
+	}
+	fix := analysis.SuggestedFix{Message: fmt.Sprintf("Rename to %s", c.To)}
+	for _, e := range c.Edits {
+		p := edit(e.Filename, e.Offset, e.Line, e.Column, false)
+		if !p.IsValid() {
+			return d
+		}
+		fix.TextEdits = append(fix.TextEdits, analysis.TextEdit{
+			Pos:     p,
+			End:     p + token.Pos(len(e.Old)),
+			NewText: []byte(e.New),
+		})
+	}
+	d.SuggestedFixes = []analysis.SuggestedFix{fix}
+	return d
+}
@@ -0,0 +1,137 @@
+package unexport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/checker"
+	"golang.org/x/tools/go/packages"
+)
+
+func TestAnalyzer(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.18\n",
+		"lib/lib.go": `package lib
+
+type T struct {
+	F int
+	G int
+}
+
+func Used() T { return Unused(T{F: 1}) }
+
+func Unused(t T) T { return t }
+
+var V int
+
+var v int
+
+var W int
+`,
+		// W is only used by a dependency of the command
+		"mid/mid.go": `package mid
+
+import "example.com/m/lib"
+
+func M() int { return lib.W }
+`,
+		"cmd/app/main.go": `package main
+
+import (
+	"example.com/m/lib"
+	"example.com/m/mid"
+)
+
+func Helper() {}
+
+func main() {
+	Helper()
+	_ = lib.Used().F
+	_ = mid.M()
+}
+`,
+	})
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.LoadAllSyntax | packages.NeedModule,
+		Dir:  dir,
+		Env:  append(os.Environ(), "GOWORK=off", "GOFLAGS="),
+	}, "./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := checker.Analyze([]*analysis.Analyzer{Analyzer}, pkgs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the facts of the packages combine the uses of their dependencies
+	uses := make(map[string][]string)
+	diagnostics := make(map[string]analysis.Diagnostic)
+	var messages []string
+	for _, act := range graph.Roots {
+		if act.Err != nil {
+			t.Fatal(act.Err)
+		}
+		var fact packageFact
+		if !act.PackageFact(act.Package.Types, &fact) {
+			t.Fatalf("no fact exported by %s", act.Package.PkgPath)
+		}
+		for _, key := range fact.Uses {
+			uses[act.Package.PkgPath] = append(uses[act.Package.PkgPath], strings.TrimPrefix(key, "example.com/m/lib "))
+		}
+		for _, d := range act.Diagnostics {
+			diagnostics[d.Message] = d
+			messages = append(messages, d.Message)
+		}
+	}
+	for path, want := range map[string][]string{
+		"example.com/m/lib":     nil,
+		"example.com/m/mid":     {"W"},
+		"example.com/m/cmd/app": {"T.UF0", "Used", "W", "example.com/m/mid M"},
+	} {
+		if !reflect.DeepEqual(uses[path], want) {
+			t.Errorf("expected %s to use %v, got %v", path, want, uses[path])
+		}
+	}
+	sort.Strings(messages)
+	want := []string{
+		`field ("example.com/m/lib".T).G is exported but not used outside of its package`,
+		`func "example.com/m/cmd/app".Helper is exported but not used outside of its package`,
+		`func "example.com/m/lib".Unused is exported but not used outside of its package`,
+		`type "example.com/m/lib".T is exported but not used outside of its package`,
+		`var "example.com/m/lib".V is exported but not used outside of its package, renaming it to v causes conflicts`,
+	}
+	if !reflect.DeepEqual(messages, want) {
+		t.Fatalf("expected diagnostics\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(messages, "\n"))
+	}
+
+	fset := graph.Roots[0].Package.Fset
+	d := diagnostics[want[1]]
+	if len(d.SuggestedFixes) != 1 {
+		t.Fatalf("expected a suggested fix, got %v", d.SuggestedFixes)
+	}
+	var edits []string
+	for _, e := range d.SuggestedFixes[0].TextEdits {
+		pos := fset.Position(e.Pos)
+		edits = append(edits, fmt.Sprintf("%s:%d:%d %s", filepath.Base(pos.Filename), pos.Line, pos.Column, e.NewText))
+	}
+	if want := []string{"main.go:8:6 helper", "main.go:11:2 helper"}; !reflect.DeepEqual(edits, want) {
+		t.Errorf("expected the edits %v, got %v", want, edits)
+	}
+
+	// the renaming edits the files of another package than the reporting one
+	if d := diagnostics[want[2]]; len(d.SuggestedFixes) != 0 {
+		t.Errorf("expected no fix, got %v", d.SuggestedFixes)
+	}
+
+	d = diagnostics[want[4]]
+	if len(d.SuggestedFixes) != 0 || len(d.Related) == 0 {
+		t.Errorf("expected the conflicts as related information and no fix, got %+v", d)
+	}
+}
@@ -0,0 +1,86 @@
+package unexport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Baseline is the set of known unnecessarily exported identifiers, by qualifier,
+// so that only the new ones are reported. It's stored as a text file, a qualifier
+// per line, the empty lines and the lines starting with # are ignored.
+type Baseline map[string]bool
+
+// NewBaseline returns the baseline of the identifiers of the report
+func NewBaseline(report *Report) Baseline {
+	b := make(Baseline)
+	for _, o := range report.Objects {
+		b[o.Qualifier] = true
+	}
+	return b
+}
+
+// ReadBaseline reads a baseline file
+func ReadBaseline(filename string) (Baseline, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := make(Baseline)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return b, nil
+}
+
+// Write writes the baseline file, the qualifiers are sorted
+func (b Baseline) Write(filename string) error {
+	var buf bytes.Buffer
+	buf.WriteString("# Exported identifiers not used outside of their package, see github.com/isaiah/unexport\n")
+	for _, q := range b.sorted() {
+		buf.WriteString(q)
+		buf.WriteByte('\n')
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// Compare returns the identifiers of the report missing from the baseline,
+// and the qualifiers of the baseline that are no longer reported, either
+// unexported, used by another package or deleted.
+func (b Baseline) Compare(report *Report) (added []ObjectReport, fixed []string) {
+	reported := make(map[string]bool)
+	for _, o := range report.Objects {
+		reported[o.Qualifier] = true
+		if !b[o.Qualifier] {
+			added = append(added, o)
+		}
+	}
+	for _, q := range b.sorted() {
+		if !reported[q] {
+			fixed = append(fixed, q)
+		}
+	}
+	return added, fixed
+}
+
+func (b Baseline) sorted() []string {
+	var qualifiers []string
+	for q := range b {
+		qualifiers = append(qualifiers, q)
+	}
+	sort.Strings(qualifiers)
+	return qualifiers
+}
@@ -0,0 +1,31 @@
+package unexport
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBaseline(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "unexport.baseline")
+	report := &Report{Objects: []ObjectReport{{Qualifier: `"a".A`}, {Qualifier: `"a".B`}}}
+	if err := NewBaseline(report).Write(filename); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := ReadBaseline(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(baseline, Baseline{`"a".A`: true, `"a".B`: true}) {
+		t.Fatalf("unexpected baseline %v", baseline)
+	}
+
+	report = &Report{Objects: []ObjectReport{{Qualifier: `"a".A`}, {Qualifier: `("a".A).C`}}}
+	added, fixed := baseline.Compare(report)
+	if len(added) != 1 || added[0].Qualifier != `("a".A).C` {
+		t.Errorf("expected the new identifier (\"a\".A).C, got %v", added)
+	}
+	if !reflect.DeepEqual(fixed, []string{`"a".B`}) {
+		t.Errorf("expected the fixed identifier \"a\".B, got %v", fixed)
+	}
+}
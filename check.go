@@ -3,6 +3,7 @@ package unexport
 import (
 	"fmt"
 	"go/ast"
+	"go/build"
 	"go/token"
 	"go/types"
 	"reflect"
@@ -28,22 +29,56 @@ import (
 // since it only uses a subset of the functionalities. e.g. it doesn't require thread-safty
 
 type Unexporter struct {
-	path               string
+	paths              map[string]bool // import paths of the packages to unexport
 	changeMethods      bool
+	ctxt               *build.Context // build context of the GOPATH loader, nil with go/packages
 	iprog              *loader.Program
 	packages           map[*types.Package]*loader.PackageInfo // subset of iprog.AllPackages to inspect
 	msets              typeutil.MethodSetCache
 	satisfyConstraints map[satisfy.Constraint]bool
-	warnings           chan map[types.Object]string
+	warnings           chan map[types.Object][]Conflict
+	testPolicy         TestPolicy
+	testUses           map[types.Object]bool // objects used from the tests of other packages
+	interfaces         []Interface           // interfaces called dynamically, see Config.Interfaces
+	generatedPolicy    GeneratedPolicy
+	project            *ProjectConfig
+	keepRules          []KeepRule
+	namer              Namer
+	generated          map[string]bool              // file names of the generated files
+	generatedRefs      map[types.Object][]token.Pos // identifiers of the generated files
 	Identifiers        map[types.Object]*ObjectInfo
+	// Kept are the exported identifiers not used by the other packages, but
+	// kept exported, with the reason, e.g. the fields encoded by encoding/json
+	Kept map[types.Object]string
+	// Stale are the //unexport:keep directives that are not needed, sorted by position
+	Stale []StaleDirective
+	// build matrix, the merged results of loading each configuration
+	views       []*Unexporter
+	label       string                          // build configuration of a view
+	owners      map[types.Object]*Unexporter    // view declaring the identifier
+	equivalents map[types.Object][]types.Object // same identifier in each view, nil if excluded
+	// rewritten files, the renamings are applied to their original contents
+	sources map[string][]byte         // original contents, by file name
+	written map[string]map[int]string // renamings written so far, by file name and offset
+	journal string                    // file recording the renamings applied, see Config.Journal
 	// memoization
 	unexportableObjects []types.Object
+	loadedPackages      []*types.Package // keys of packages, sorted by path
 	lexinfos            map[*loader.PackageInfo]*lexical.Info
+	objIndexes          map[*loader.PackageInfo]map[objKey]types.Object
 	mutex               sync.Mutex
 }
 
 type ObjectInfo struct {
-	Warning      string
+	// Warning is the description of the conflicts, one per line
+	Warning   string
+	Conflicts []Conflict
+	// TestOnly is set if the identifier is used by the tests of other packages,
+	// and nothing else, with the TestUsesReport policy
+	TestOnly bool
+	// Unproven lists the packages that failed to type-check, and may use the
+	// identifier, the renaming can't be proven safe, see Config.AllowErrors
+	Unproven     []string
 	objsToUpdate map[types.Object]string
 }
 
@@ -52,6 +87,11 @@ func (r *Unexporter) check(objsToUpdate map[types.Object]string, from types.Obje
 		return
 	}
 	objsToUpdate[from] = to
+	r.checkGenerated(from, to)
+	if !isValidIdentifier(to) {
+		r.warn(from, r.errorf(from.Pos(), "%q is not a valid identifier", to))
+		return
+	}
 
 	// NB: order of conditions is important.
 	if from_, ok := from.(*types.PkgName); ok {
@@ -114,10 +154,11 @@ func (r *Unexporter) checkInPackageBlock(objsToUpdate map[types.Object]string, f
 	// package if the renaming would make it unexported.
 	if ast.IsExported(from.Name()) && !ast.IsExported(to) {
 		for pkg, info := range r.packages {
-			if pkg == from.Pkg() {
+			// including the test variant of the package
+			if pkg.Path() == from.Pkg().Path() {
 				continue
 			}
-			if id := someUse(info, from); id != nil &&
+			if id := r.someUse(info, from); id != nil &&
 				!r.checkExport(id, pkg, from, to) {
 				break
 			}
@@ -125,6 +166,9 @@ func (r *Unexporter) checkInPackageBlock(objsToUpdate map[types.Object]string, f
 	}
 
 	info := r.packages[from.Pkg()]
+	if len(info.Errors) > 0 {
+		return // the lexical structure of broken packages isn't reliable, see ObjectInfo.Unproven
+	}
 	lexinfo := r.lexInfo(info)
 
 	// Check that in the package block, "init" is a function, and never referenced.
@@ -231,9 +275,14 @@ func (r *Unexporter) checkInLocalScope(objsToUpdate map[types.Object]string, fro
 // requires no checks.
 //
 func (r *Unexporter) checkInLexicalScope(objsToUpdate map[types.Object]string, from types.Object, to string, info *loader.PackageInfo) {
+	if len(info.Errors) > 0 {
+		return // see checkInPackageBlock
+	}
 	lexinfo := r.lexInfo(info)
+	// the object of 'from' as seen by info, it differs in the test variants
+	obj := r.equivalent(info, from)
 
-	b := lexinfo.Defs[from] // the block defining the 'from' object
+	b := lexinfo.Defs[obj] // the block defining the 'from' object
 	if b != nil {
 		to, toBlock := b.Lookup(to)
 		if toBlock == b {
@@ -249,7 +298,7 @@ func (r *Unexporter) checkInLexicalScope(objsToUpdate map[types.Object]string, f
 			// The name to is defined in a superblock.
 			// Is that name referenced from within this block?
 			for _, ref := range lexinfo.Refs[to] {
-				if obj, _ := ref.Env.Lookup(from.Name()); obj == from {
+				if o, _ := ref.Env.Lookup(from.Name()); o == obj {
 					// super-block conflict
 					r.warn(from,
 						r.errorf(from.Pos(), "renaming this %s %q to %q",
@@ -266,7 +315,7 @@ func (r *Unexporter) checkInLexicalScope(objsToUpdate map[types.Object]string, f
 	// Check for sub-block conflict.
 	// Is there an intervening definition of to between
 	// the block defining 'from' and some reference to it?
-	for _, ref := range lexinfo.Refs[from] {
+	for _, ref := range lexinfo.Refs[obj] {
 		// TODO(adonovan): think about dot imports.
 		// (Is b == fromBlock an invariant?)
 		_, fromBlock := ref.Env.Lookup(from.Name())
@@ -293,10 +342,10 @@ func (r *Unexporter) checkInLexicalScope(objsToUpdate map[types.Object]string, f
 	// 	var s struct {T}
 	// 	print(s.T) // ...this must change too
 	if _, ok := from.(*types.TypeName); ok {
-		for id, obj := range info.Uses {
-			if obj == from {
+		for id, o := range info.Uses {
+			if o == obj {
 				if field := info.Defs[id]; field != nil {
-					r.check(objsToUpdate, field, to)
+					r.check(objsToUpdate, r.canonical(field), to)
 				}
 			}
 		}
@@ -394,7 +443,7 @@ func (r *Unexporter) checkStructField(objsToUpdate map[types.Object]string, from
 // the specified object would continue to do so after the renaming.
 func (r *Unexporter) checkSelections(objsToUpdate map[types.Object]string, from types.Object, to string) {
 	for pkg, info := range r.packages {
-		if id := someUse(info, from); id != nil {
+		if id := r.someUse(info, from); id != nil {
 			if !r.checkExport(id, pkg, from, to) {
 				return
 			}
@@ -423,7 +472,7 @@ func (r *Unexporter) checkSelections(objsToUpdate map[types.Object]string, from
 			// TODO(adonovan): test with pointer, value, addressable value.
 			isAddressable := true
 
-			if sel.Obj() == from {
+			if r.canonical(sel.Obj()) == from {
 				if obj, indices, _ := types.LookupFieldOrMethod(sel.Recv(), isAddressable, from.Pkg(), to); obj != nil {
 					// Renaming this existing selection of
 					// 'from' may block access to an existing
@@ -437,7 +486,7 @@ func (r *Unexporter) checkSelections(objsToUpdate map[types.Object]string, from
 				}
 
 			} else if sel.Obj().Name() == to {
-				if obj, indices, _ := types.LookupFieldOrMethod(sel.Recv(), isAddressable, from.Pkg(), from.Name()); obj == from {
+				if obj, indices, _ := types.LookupFieldOrMethod(sel.Recv(), isAddressable, from.Pkg(), from.Name()); r.canonical(obj) == from {
 					// Renaming 'from' may cause this existing
 					// selection of the name 'to' to change
 					// its meaning.
@@ -574,10 +623,10 @@ func (r *Unexporter) checkMethod(objsToUpdate map[types.Object]string, from *typ
 			// and one of them is m, the other must be coupled.
 			var coupled *types.Func
 			switch from {
-			case lsel.Obj():
-				coupled = rsel.Obj().(*types.Func)
-			case rsel.Obj():
-				coupled = lsel.Obj().(*types.Func)
+			case r.canonical(lsel.Obj()):
+				coupled = r.canonical(rsel.Obj()).(*types.Func)
+			case r.canonical(rsel.Obj()):
+				coupled = r.canonical(lsel.Obj()).(*types.Func)
 			default:
 				continue
 			}
@@ -679,14 +728,14 @@ func (r *Unexporter) checkMethod(objsToUpdate map[types.Object]string, from *typ
 				continue
 			}
 			rsel := r.msets.MethodSet(key.RHS).Lookup(from.Pkg(), from.Name())
-			if rsel == nil || rsel.Obj() != from {
+			if rsel == nil || r.canonical(rsel.Obj()) != from {
 				continue // rhs does not have the method
 			}
 			lsel := r.msets.MethodSet(key.LHS).Lookup(from.Pkg(), from.Name())
 			if lsel == nil {
 				continue
 			}
-			imeth := lsel.Obj().(*types.Func)
+			imeth := r.canonical(lsel.Obj()).(*types.Func)
 
 			// imeth is the abstract method (e.g. I.f)
 			// and key.RHS is the concrete coupling type (e.g. D).
@@ -727,7 +776,7 @@ func (r *Unexporter) checkExport(id *ast.Ident, pkg *types.Package, from types.O
 	// Reject cross-package references if to is unexported.
 	// (Such references may be qualified identifiers or field/method
 	// selections.)
-	if !ast.IsExported(to) && pkg != from.Pkg() {
+	if !ast.IsExported(to) && pkg.Path() != from.Pkg().Path() {
 		r.warn(from,
 			r.errorf(from.Pos(),
 				"renaming this %s %q to %q would make it unexported",
@@ -745,7 +794,10 @@ func (r *Unexporter) satisfy() map[satisfy.Constraint]bool {
 		// Compute on demand: it's expensive.
 		var f satisfy.Finder
 		for _, info := range r.packages {
-			f.Find(&info.Info, info.Files)
+			// the finder expects complete type information
+			if len(info.Errors) == 0 {
+				f.Find(&info.Info, info.Files)
+			}
 		}
 		r.satisfyConstraints = f.Result
 	}
@@ -760,15 +812,65 @@ func recv(meth *types.Func) *types.Var {
 }
 
 // someUse returns an arbitrary use of obj within info.
-func someUse(info *loader.PackageInfo, obj types.Object) *ast.Ident {
+func (r *Unexporter) someUse(info *loader.PackageInfo, obj types.Object) *ast.Ident {
 	for id, o := range info.Uses {
-		if o == obj {
+		if r.canonical(o) == obj {
 			return id
 		}
 	}
 	return nil
 }
 
+// objKey identifies an object by its declaration, an embedded field shares
+// the identifier with its type, hence the kind of object.
+type objKey struct {
+	pos  token.Pos
+	kind reflect.Type
+}
+
+// canonical returns the object of the package (not its test variant) declaring obj.
+// The test variants of a package are type-checked separately, an identifier
+// is represented by a different object in each of them.
+func (r *Unexporter) canonical(obj types.Object) types.Object {
+	if obj == nil || obj.Pkg() == nil {
+		return obj
+	}
+	if info := r.iprog.Imported[obj.Pkg().Path()]; info != nil && info.Pkg != obj.Pkg() {
+		return r.equivalent(info, obj)
+	}
+	return obj
+}
+
+// equivalent returns the object that represents the declaration of obj within info.
+func (r *Unexporter) equivalent(info *loader.PackageInfo, obj types.Object) types.Object {
+	if obj == nil || !obj.Pos().IsValid() {
+		return obj
+	}
+	if o := r.objIndex(info)[objKey{obj.Pos(), reflect.TypeOf(obj)}]; o != nil {
+		return o
+	}
+	return obj
+}
+
+func (r *Unexporter) objIndex(info *loader.PackageInfo) map[objKey]types.Object {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if index := r.objIndexes[info]; index != nil {
+		return index
+	}
+	index := make(map[objKey]types.Object)
+	for _, obj := range info.Defs {
+		if obj != nil {
+			index[objKey{obj.Pos(), reflect.TypeOf(obj)}] = obj
+		}
+	}
+	for _, obj := range info.Uses {
+		index[objKey{obj.Pos(), reflect.TypeOf(obj)}] = obj
+	}
+	r.objIndexes[info] = index
+	return index
+}
+
 // -- Plundered from golang.org/x/tools/go/ssa -----------------
 
 func isInterface(t types.Type) bool { return types.IsInterface(t) }
@@ -814,12 +916,49 @@ func objectKind(obj types.Object) string {
 	return strings.ToLower(strings.TrimPrefix(reflect.TypeOf(obj).String(), "*types."))
 }
 
+// Conflict is a line of the report of a renaming conflict, a conflict is
+// reported by several lines, the first one is the renaming, the others,
+// indented by a tab, are the related declarations and references.
+type Conflict struct {
+	Pos     token.Position
+	Message string
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: %s", c.Pos, c.Message)
+}
+
+// setConflicts sets the conflicts, the ones reported more than once are dropped
+func (info *ObjectInfo) setConflicts(conflicts []Conflict) {
+	info.Conflicts = nil
+	var lines []string
+	seen := make(map[string]bool)
+	for i := 0; i < len(conflicts); {
+		// a conflict and its indented lines
+		j := i + 1
+		for j < len(conflicts) && strings.HasPrefix(conflicts[j].Message, "\t") {
+			j++
+		}
+		var report []string
+		for _, c := range conflicts[i:j] {
+			report = append(report, c.String())
+		}
+		if key := strings.Join(report, "\n"); !seen[key] {
+			seen[key] = true
+			info.Conflicts = append(info.Conflicts, conflicts[i:j]...)
+			lines = append(lines, report...)
+		}
+		i = j
+	}
+	info.Warning = strings.Join(lines, "\n")
+}
+
 // errorf reports an error (e.g. conflict) and prevents file modification.
-func (r *Unexporter) errorf(pos token.Pos, format string, args ...interface{}) string {
-	return fmt.Sprintf("%s: %s", r.iprog.Fset.Position(pos), fmt.Sprintf(format, args...))
+func (r *Unexporter) errorf(pos token.Pos, format string, args ...interface{}) Conflict {
+	return Conflict{Pos: r.iprog.Fset.Position(pos), Message: fmt.Sprintf(format, args...)}
 }
-func (r *Unexporter) warn(from types.Object, warnings ...string) {
-	r.warnings <- map[types.Object]string{from: strings.Join(warnings, "\n")}
+func (r *Unexporter) warn(from types.Object, conflicts ...Conflict) {
+	r.warnings <- map[types.Object][]Conflict{from: conflicts}
 }
 
 func (r *Unexporter) lexInfo(info *loader.PackageInfo) *lexical.Info {
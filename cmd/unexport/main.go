@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -8,9 +9,11 @@ import (
 	"go/types"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime/pprof"
 	t "runtime/trace"
+	"sort"
 	"strings"
 
 	"github.com/isaiah/unexport"
@@ -18,11 +21,27 @@ import (
 )
 
 var (
-	helpFlag = flag.Bool("help", false, "show usage message")
-	runall   = flag.Bool("all", false, "run all renaming, aborts if there are unsolved conflicts")
-	dryrun   = flag.Bool("dryrun", false, "show the unused identifiers, but do not apply renaming")
-	profile  = flag.Bool("profile", false, "memory profile")
-	trace    = flag.Bool("trace", false, "trace goroutine execution")
+	helpFlag    = flag.Bool("help", false, "show usage message")
+	runall      = flag.Bool("all", false, "run all renaming, aborts if there are unsolved conflicts")
+	alternative = flag.Bool("alternatives", false, "with -all, rename the identifiers causing conflicts to their best conflict-free alternative name")
+	dryrun      = flag.Bool("dryrun", false, "show the unused identifiers, but do not apply renaming")
+	jsonFlag    = flag.Bool("json", false, "print a JSON report of the unused identifiers, their renamings and conflicts, but do not apply renaming")
+	diff        = flag.Bool("diff", false, "print the unified diff of the renamings free of conflicts, in every affected file, but do not apply renaming")
+	planFile    = flag.String("plan", "", "write the renamings of every unused identifier to a plan file, to edit and apply with -apply, but do not apply renaming")
+	applyFile   = flag.String("apply", "", "check again and apply the renamings of a plan file, or of a JSON mapping of qualifier to new name, the ones causing conflicts are skipped")
+	sarif       = flag.Bool("sarif", false, "print a SARIF 2.1.0 log of the unused identifiers, with the renamings as fixes, but do not apply renaming")
+	baseline    = flag.String("baseline", "", "baseline file of the known unused identifiers, only the other ones are reported, and the exit status is 1 if there are any")
+	write       = flag.Bool("writebaseline", false, "write the unused identifiers to the -baseline file")
+	journal     = flag.String("journal", "", "file recording the renamings applied, to undo them, by default the "+unexport.JournalFile+" file at the root of the module or go.work workspace of the first package, nothing is recorded if off")
+	undo        = flag.Int("undo", 0, "revert the last N renamings recorded in the -journal file, if their files have not changed since")
+	profile     = flag.Bool("profile", false, "memory profile")
+	trace       = flag.Bool("trace", false, "trace goroutine execution")
+	tests       = flag.Bool("tests", true, "load the tests of the packages, including external test packages and examples")
+	matrix      = flag.String("matrix", "", "build configurations analyzed together, separated by spaces, each of the form GOOS/GOARCH:tag1,tag2, e.g. \"linux/amd64 windows/amd64 linux/amd64:integration\"")
+	allowErrors = flag.Bool("e", false, "tolerate packages that fail to type-check, identifiers that may be used by them are marked as unproven")
+	testUses    = flag.String("testuses", "keep", "how the uses from tests are considered: keep the identifier exported, ignore them, or report the identifiers used only by tests")
+	configFile  = flag.String("config", "", "project configuration file, by default the .unexport.json file of the directory of the first package or its closest parent having one")
+	generated   = flag.String("generated", "keep", "how the uses from generated files are considered: keep the identifier exported, or ignore them, the generated files are never rewritten")
 
 	errNotGoSourcePath = errors.New("path is not under GOROOT or GOPATH")
 )
@@ -33,15 +52,16 @@ func init() {
 	flag.Usage = func() {
 		usage := `unexport: a tool that finds unnecessarily exported identifiers in a package and help unexport them
 
-By default it checks the current directory, if it's in the GOPATH, otherwise you can specify the
-targeting package as argument
+By default it checks the package in the current directory, either in a Go module (or go.work
+workspace) or in the GOPATH, otherwise you can specify the targeting packages as arguments, patterns such as ./... (or foo/... in GOPATH mode) are accepted,
+the matched packages are analyzed together in a single run
 
 It prompt the unexport of each identifier, you can choose to unexport, skip or apply an alternative name.
 by default the resulting name is the original name with its first letter downcased.
 
 Usage:
 
-  unexport <flags> [package]
+  unexport <flags> [packages]
 
 Flags:
 `
@@ -56,12 +76,39 @@ func main() {
 		flag.Usage()
 		return
 	}
+	if *journal == "" {
+		dir := "."
+		if flag.NArg() > 0 {
+			dir = packageDir(flag.Arg(0))
+		}
+		*journal = journalFile(dir)
+	} else if *journal == "off" {
+		*journal = ""
+	}
+	if *undo > 0 {
+		if *journal == "" {
+			fmt.Fprintln(os.Stderr, "-undo needs a -journal file")
+			os.Exit(2)
+		}
+		ops, err := unexport.Undo(*journal, *undo)
+		for _, op := range ops {
+			fmt.Printf("Reverted the %s\n", op)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	ctxt := &build.Default
-	var path string
-	if len(flag.Args()) == 0 {
-		path = getwdPackages(ctxt)
-	} else {
-		path = flag.Args()[0]
+	modules := inModule()
+	paths := flag.Args()
+	if len(paths) == 0 {
+		if modules {
+			paths = []string{"."}
+		} else {
+			paths = []string{getwdPackages(ctxt)}
+		}
 	}
 	if *trace {
 		f, err := os.Create("unexport_trace.json")
@@ -75,26 +122,160 @@ func main() {
 		}()
 	}
 
-	unexporter, err := unexport.New(ctxt, path)
+	conf := &unexport.Config{Tests: *tests, AllowErrors: *allowErrors, Journal: *journal}
+	if !modules {
+		conf.Context = ctxt
+	}
+	switch *testUses {
+	case "keep":
+		conf.TestPolicy = unexport.TestUsesKeep
+	case "ignore":
+		conf.TestPolicy = unexport.TestUsesIgnore
+	case "report":
+		conf.TestPolicy = unexport.TestUsesReport
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -testuses %q, expected keep, ignore or report\n", *testUses)
+		os.Exit(2)
+	}
+	switch *generated {
+	case "keep":
+		conf.GeneratedPolicy = unexport.GeneratedUsesKeep
+	case "ignore":
+		conf.GeneratedPolicy = unexport.GeneratedUsesIgnore
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -generated %q, expected keep or ignore\n", *generated)
+		os.Exit(2)
+	}
+	var err error
+	if *configFile != "" {
+		conf.Project, err = unexport.ReadProjectConfig(*configFile)
+	} else {
+		conf.Project, err = unexport.FindProjectConfig(packageDir(paths[0]))
+	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if conf.Project != nil && unexport.Verbose {
+		log.Printf("Using the configuration %s\n", conf.Project.Filename)
+	}
+	for _, s := range strings.Fields(*matrix) {
+		bc, err := unexport.ParseBuildConfig(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		conf.Matrix = append(conf.Matrix, bc)
+	}
+	unexporter, err := unexport.Load(conf, paths...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 	if *trace {
 		os.Exit(0)
 	}
+	if *baseline != "" {
+		os.Exit(checkBaseline(unexporter, *baseline, *write))
+	}
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(unexporter.Report()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *sarif {
+		// the files are relative to the current directory, the root of the repository
+		wd, err := os.Getwd()
+		if err != nil {
+			wd = ""
+		}
+		if err := unexporter.Report().WriteSARIF(os.Stdout, wd); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *planFile != "" {
+		if err := unexport.NewPlan(unexporter.Report()).Write(*planFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *applyFile != "" {
+		plan, err := unexport.ReadPlan(*applyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		applied, errs := unexporter.ApplyPlan(plan)
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		fmt.Printf("Applied %d renaming%s of %d.\n", applied, plural(applied), len(plan.Renamings))
+		recorded(applied)
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *diff {
+		var objs []types.Object
+		for _, obj := range unexporter.UnusedObjectsSorted() {
+			info := unexporter.Identifiers[obj]
+			if info.Warning != "" || len(info.Unproven) > 0 {
+				fmt.Fprintf(os.Stderr, "skipping %s, it causes conflicts or is not proven safe\n", unexporter.Qualifier(obj))
+				continue
+			}
+			objs = append(objs, obj)
+		}
+		// the files are relative to the current directory, for git apply
+		wd, err := os.Getwd()
+		if err != nil {
+			wd = ""
+		}
+		if err := unexporter.Diff(os.Stdout, wd, objs...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	if *dryrun {
-		fmt.Println(`Following identifiers are exported but not used anywhere out of the package:
+		fmt.Print(`Following identifiers are exported but not used anywhere out of their package:
 (The qualifiers are valid for gorename command)
+
 `)
 		for _, obj := range unexporter.UnusedObjectsSorted() {
 			info := unexporter.Identifiers[obj]
 
-			if info.Warning == "" {
-				fmt.Println(unexporter.Qualifier(obj))
+			if info.Warning == "" && info.TestOnly {
+				fmt.Printf("%s (used by tests only)%s\n", unexporter.Qualifier(obj), unproven(info))
+			} else if info.Warning == "" {
+				fmt.Printf("%s%s\n", unexporter.Qualifier(obj), unproven(info))
 			} else {
 				fmt.Printf("unexport %s causes conflict:\n%s\n", unexporter.Qualifier(obj), info.Warning)
+				if names := unexporter.Alternatives(obj, 3); len(names) > 0 {
+					fmt.Printf("conflict-free alternative names: %s\n", strings.Join(names, ", "))
+				}
 			}
 		}
+		if len(unexporter.Kept) > 0 {
+			fmt.Print("\nFollowing identifiers are not used out of their package, but kept exported:\n")
+			for _, kept := range unexporter.Report().Kept {
+				fmt.Printf("%s: %s\n", kept.Qualifier, kept.Reason)
+			}
+		}
+		if len(unexporter.Stale) > 0 {
+			fmt.Print("\nFollowing //unexport:keep directives are stale:\n")
+			for _, d := range unexporter.Stale {
+				fmt.Println(d)
+			}
+		}
+		printErrors(unexporter)
 		os.Exit(0)
 	}
 	if *profile {
@@ -109,36 +290,56 @@ func main() {
 	if *runall {
 		var conflict bool
 		for obj, info := range unexporter.Identifiers {
+			if info.Warning != "" && *alternative && len(info.Unproven) == 0 {
+				if names := unexporter.Alternatives(obj, 1); len(names) > 0 {
+					fmt.Printf("unexport %s as %s to avoid conflicts\n", unexporter.Qualifier(obj), names[0])
+					unexporter.Check(obj, names[0])
+					continue
+				}
+			}
 			if info.Warning != "" {
 				fmt.Printf("unexport %s causes conflicts\n%s", unexporter.Qualifier(obj), info.Warning)
 				conflict = true
+			} else if len(info.Unproven) > 0 {
+				fmt.Printf("unexport %s is not proven safe%s\n", unexporter.Qualifier(obj), unproven(info))
+				conflict = true
 			}
 		}
 		if conflict {
+			printErrors(unexporter)
 			fmt.Println("Please fix the conflicts before continue.")
 			os.Exit(1)
 		}
-		unexporter.UpdateAll()
+		if err := unexporter.UpdateAll(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		recorded(1)
 		os.Exit(0)
 	}
 
 	// apply the changes
 	fmt.Println("Please press corresponding key to proceed, y to confirm, n to skip, r to use a different name and c to cancel:")
+	var updated int
+	defer func() { recorded(updated) }()
 	for _, obj := range unexporter.UnusedObjectsSorted() {
 		info := unexporter.Identifiers[obj]
 		var s string
 		if info.Warning == "" {
-			fmt.Printf("unexport %s, y/n/r/c? ", unexporter.Qualifier(obj))
+			fmt.Printf("unexport %s%s, y/n/r/c? ", unexporter.Qualifier(obj), unproven(info))
 		} else {
 			fmt.Printf("unexport %s causes conflicts\n%s, \nn/r/c? ", unexporter.Qualifier(obj), info.Warning)
 		}
 		fmt.Scanf("%s", &s)
 		switch s {
 		case "y", "Y":
-			unexporter.Update(obj)
+			update(unexporter, obj)
+			updated++
 		case "r":
 			rename(unexporter, obj, info)
+			updated++
 		case "c":
+			recorded(updated)
 			os.Exit(1)
 		default:
 			continue
@@ -146,13 +347,123 @@ func main() {
 	}
 }
 
+// checkBaseline reports the unused identifiers missing from the baseline file,
+// and the ones that are no longer unused, it returns the exit status
+func checkBaseline(unexporter *unexport.Unexporter, filename string, write bool) int {
+	report := unexporter.Report()
+	if write {
+		if err := unexport.NewBaseline(report).Write(filename); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("%d identifier%s recorded in %s\n", len(report.Objects), plural(len(report.Objects)), filename)
+		return 0
+	}
+	b, err := unexport.ReadBaseline(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	added, fixed := b.Compare(report)
+	if len(fixed) > 0 {
+		fmt.Printf("Following identifiers of the baseline are no longer exported and unused, they can be removed from %s:\n", filename)
+		for _, q := range fixed {
+			fmt.Println(q)
+		}
+	}
+	if len(added) == 0 {
+		return 0
+	}
+	fmt.Println("Following identifiers are exported but not used anywhere out of their package, and not in the baseline:")
+	for _, o := range added {
+		fmt.Printf("%s:%d:%d: %s\n", o.Pos.Filename, o.Pos.Line, o.Pos.Column, o.Qualifier)
+	}
+	return 1
+}
+
+func plural(n int) string {
+	if n != 1 {
+		return "s"
+	}
+	return ""
+}
+
+// unproven describes the packages that failed to type-check and may use the identifier
+func unproven(info *unexport.ObjectInfo) string {
+	if len(info.Unproven) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (unproven, %s failed to type-check)", strings.Join(info.Unproven, ", "))
+}
+
+// printErrors lists the packages that failed to type-check
+func printErrors(unexporter *unexport.Unexporter) {
+	errs := unexporter.Errors()
+	if len(errs) == 0 {
+		return
+	}
+	var paths []string
+	for path := range errs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	fmt.Println("\nFollowing packages failed to type-check:")
+	for _, path := range paths {
+		fmt.Printf("%s: %v\n", path, errs[path][0])
+	}
+}
+
+// recorded prints where the last n renamings are recorded, to undo them
+func recorded(n int) {
+	if *journal != "" && n > 0 {
+		fmt.Printf("Recorded in %s, undo with: unexport -journal %s -undo %d\n", *journal, *journal, n)
+	}
+}
+
+// journalFile returns the default journal of the packages of dir, at the root
+// of their go.work workspace or module, or in dir in GOPATH mode
+func journalFile(dir string) string {
+	cmd := exec.Command("go", "env", "GOWORK", "GOMOD")
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err == nil {
+		for _, file := range strings.Fields(string(out)) {
+			if file != os.DevNull && file != "off" {
+				return filepath.Join(filepath.Dir(file), unexport.JournalFile)
+			}
+		}
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return filepath.Join(dir, unexport.JournalFile)
+}
+
+// update applies the renaming of obj, the command stops if it fails
+func update(unexporter *unexport.Unexporter, obj types.Object) {
+	if err := unexporter.Update(obj); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
 func rename(unexporter *unexport.Unexporter, obj types.Object, info *unexport.ObjectInfo) {
 	var to string
-	fmt.Printf("please input an alternative name: ")
+	names := unexporter.Alternatives(obj, 3)
+	switch len(names) {
+	case 0:
+		fmt.Printf("please input an alternative name: ")
+	case 1:
+		fmt.Printf("please input an alternative name, or press enter for %s: ", names[0])
+	default:
+		fmt.Printf("please input an alternative name, or press enter for %s (also conflict-free: %s): ", names[0], strings.Join(names[1:], ", "))
+	}
 	fmt.Scanf("%s", &to)
+	if to == "" && len(names) > 0 {
+		to = names[0]
+	}
 	warnings := unexporter.Check(obj, to)
 	if warnings == "" {
-		unexporter.Update(obj)
+		update(unexporter, obj)
 	} else {
 		fmt.Printf("rename %s to %s still causes conflicts\n%s,\nr/c/A? ",
 			unexporter.Qualifier(obj), to, warnings)
@@ -162,6 +473,38 @@ func rename(unexporter *unexport.Unexporter, obj types.Object, info *unexport.Ob
 
 }
 
+// packageDir returns the directory of the package, or of the root of the
+// pattern, e.g. sub of ./sub/..., the project configuration is searched from it
+func packageDir(pattern string) string {
+	dir := strings.TrimSuffix(pattern, "/...")
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		return dir
+	}
+	out, err := exec.Command("go", "list", "-find", "-f", "{{.Dir}}", dir).Output()
+	if err != nil {
+		return "."
+	}
+	if lines := strings.Fields(string(out)); len(lines) > 0 {
+		return lines[0]
+	}
+	return "."
+}
+
+// inModule reports whether the current directory is in a Go module, or in a
+// go.work workspace, the packages are then loaded with go/packages
+func inModule() bool {
+	out, err := exec.Command("go", "env", "GOMOD", "GOWORK").Output()
+	if err != nil {
+		return false
+	}
+	for _, file := range strings.Fields(string(out)) {
+		if file != os.DevNull && file != "off" {
+			return true
+		}
+	}
+	return false
+}
+
 func getImportPath(ctxt *build.Context, pathOrFilename string) (string, error) {
 	dirSlash := filepath.ToSlash(pathOrFilename)
 
@@ -0,0 +1,34 @@
+// The unexportls command is a language server reporting the unnecessarily
+// exported identifiers of the workspace, over stdin and stdout:
+//
+//	unexportls [packages]
+//
+// The packages, ./... by default, are relative to the root of the workspace.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/isaiah/unexport"
+	"github.com/isaiah/unexport/lsp"
+)
+
+var (
+	tests       = flag.Bool("tests", true, "load the tests of the packages, including external test packages and examples")
+	allowErrors = flag.Bool("e", false, "tolerate packages that fail to type-check, identifiers that may be used by them are marked as unproven")
+)
+
+func main() {
+	flag.Parse()
+	patterns := flag.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	conf := &unexport.Config{Tests: *tests, AllowErrors: *allowErrors}
+	if err := lsp.Serve(conf, os.Stdin, os.Stdout, patterns...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
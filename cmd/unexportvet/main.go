@@ -0,0 +1,17 @@
+// The unexportvet command runs the unexport analyzer, standalone or as a vet tool:
+//
+//	unexportvet ./...
+//	go vet -vettool=$(which unexportvet) ./...
+//
+// The uses of each package are exported as facts, the unused identifiers of
+// the main module are reported by the commands using them, or by the packages
+// given by -roots. The renaming is a suggested fix, applied by -fix, if it only
+// edits the files of the reporting package.
+package main
+
+import (
+	"github.com/isaiah/unexport"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() { singlechecker.Main(unexport.Analyzer) }
@@ -0,0 +1,223 @@
+package unexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ProjectConfigFile is the name of the project configuration file, see FindProjectConfig
+const ProjectConfigFile = ".unexport.json"
+
+// ProjectConfig is the policy of a project, committed next to the code in
+// a .unexport.json file, e.g.
+//
+//	{
+//		"exclude": ["example.com/m/api/..."],
+//		"exclude_identifiers": ["*.Test*"],
+//		"kinds": ["func", "method", "type"],
+//		"ignore_consumers": ["example.com/m/internal/tools/..."],
+//		"naming": {"example.com/m/foo": {"URL": "url", "T.ID": "id"}}
+//	}
+//
+// The patterns are globs, where * matches any sequence of characters, as
+// well as ..., or regular expressions between slashes, e.g. "/^Test/". The
+// packages are matched by import path, and the identifiers by their dotted
+// name, e.g. example.com/m/foo.T.Method.
+type ProjectConfig struct {
+	// Include are the packages to unexport, all of them if empty
+	Include []string `json:"include,omitempty"`
+	// Exclude are the packages left as they are
+	Exclude []string `json:"exclude,omitempty"`
+	// IncludeIdentifiers are the identifiers to unexport, all of them if empty
+	IncludeIdentifiers []string `json:"include_identifiers,omitempty"`
+	// ExcludeIdentifiers are the identifiers left exported
+	ExcludeIdentifiers []string `json:"exclude_identifiers,omitempty"`
+	// Kinds are the kinds of the identifiers to unexport: const, func, type,
+	// var, field or method, all of them if empty
+	Kinds []string `json:"kinds,omitempty"`
+	// IgnoreConsumers are the packages whose uses are not considered, e.g.
+	// tools that are not maintained, the identifiers they use are reported
+	// with a conflict
+	IgnoreConsumers []string `json:"ignore_consumers,omitempty"`
+	// Naming are the new names of the identifiers, by package pattern and
+	// dotted name in the package, e.g. "T.Field"
+	Naming map[string]map[string]string `json:"naming,omitempty"`
+	// Interfaces replace Config.Interfaces if not nil
+	Interfaces []Interface `json:"interfaces,omitempty"`
+
+	// Filename is the file the configuration is read from
+	Filename string `json:"-"`
+
+	patterns map[string]*regexp.Regexp
+}
+
+// ReadProjectConfig reads a project configuration file
+func ReadProjectConfig(filename string) (*ProjectConfig, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var pc ProjectConfig
+	if err := json.Unmarshal(b, &pc); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	pc.Filename = filename
+	if err := pc.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &pc, nil
+}
+
+// FindProjectConfig reads the .unexport.json file of dir, or of the closest
+// parent directory having one, it returns nil if none is found
+func FindProjectConfig(dir string) (*ProjectConfig, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		filename := filepath.Join(dir, ProjectConfigFile)
+		if _, err := os.Stat(filename); err == nil {
+			return ReadProjectConfig(filename)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// compile checks the patterns and the kinds
+func (pc *ProjectConfig) compile() error {
+	pc.patterns = make(map[string]*regexp.Regexp)
+	lists := [][]string{pc.Include, pc.Exclude, pc.IncludeIdentifiers, pc.ExcludeIdentifiers, pc.IgnoreConsumers}
+	for pattern := range pc.Naming {
+		lists = append(lists, []string{pattern})
+	}
+	for _, patterns := range lists {
+		for _, pattern := range patterns {
+			re, err := compilePattern(pattern)
+			if err != nil {
+				return err
+			}
+			pc.patterns[pattern] = re
+		}
+	}
+	for _, kind := range pc.Kinds {
+		switch kind {
+		case "const", "func", "type", "var", "field", "method":
+		default:
+			return fmt.Errorf("invalid kind %q, expected const, func, type, var, field or method", kind)
+		}
+	}
+	return nil
+}
+
+// compilePattern compiles a glob, or a regular expression between slashes
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		return re, nil
+	}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for s := pattern; s != ""; {
+		switch {
+		case strings.HasPrefix(s, "/..."):
+			// x/... matches x too, as the go command patterns
+			expr.WriteString("(/.*)?")
+			s = s[4:]
+		case strings.HasPrefix(s, "..."):
+			expr.WriteString(".*")
+			s = s[3:]
+		case s[0] == '*':
+			expr.WriteString(".*")
+			s = s[1:]
+		case s[0] == '?':
+			expr.WriteString(".")
+			s = s[1:]
+		default:
+			expr.WriteString(regexp.QuoteMeta(s[:1]))
+			s = s[1:]
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// match reports whether s matches any of the patterns
+func (pc *ProjectConfig) match(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		re := pc.patterns[pattern]
+		if re == nil {
+			// not read by ReadProjectConfig
+			var err error
+			if re, err = compilePattern(pattern); err != nil {
+				continue
+			}
+		}
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// includesPackage reports whether the identifiers of the package are considered
+func (pc *ProjectConfig) includesPackage(path string) bool {
+	if pc == nil {
+		return true
+	}
+	return (len(pc.Include) == 0 || pc.match(pc.Include, path)) && !pc.match(pc.Exclude, path)
+}
+
+// includesIdentifier reports whether the identifier, of the dotted name, is considered
+func (pc *ProjectConfig) includesIdentifier(name, kind string) bool {
+	if pc == nil {
+		return true
+	}
+	if len(pc.Kinds) > 0 && !contains(pc.Kinds, kind) {
+		return false
+	}
+	return (len(pc.IncludeIdentifiers) == 0 || pc.match(pc.IncludeIdentifiers, name)) && !pc.match(pc.ExcludeIdentifiers, name)
+}
+
+// ignoresConsumer reports whether the uses of the package are not considered
+func (pc *ProjectConfig) ignoresConsumer(path string) bool {
+	return pc != nil && pc.match(pc.IgnoreConsumers, path)
+}
+
+// newName returns the name overriding the default one, if any, name is the
+// dotted name in the package
+func (pc *ProjectConfig) newName(path, name string) (string, bool) {
+	if pc == nil {
+		return "", false
+	}
+	var patterns []string
+	for pattern := range pc.Naming {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if to, ok := pc.Naming[pattern][name]; ok && pc.match([]string{pattern}, path) {
+			return to, true
+		}
+	}
+	return "", false
+}
+
+// dottedName returns the name of obj matched by the project configuration,
+// e.g. example.com/m/foo.T.Method, from its qualifier
+func dottedName(qualifier string) string {
+	return strings.NewReplacer("(", "", ")", "", "\"", "").Replace(qualifier)
+}
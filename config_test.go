@@ -0,0 +1,109 @@
+package unexport
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCompilePattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		match   []string
+		nomatch []string
+	}{
+		{"example.com/m/foo", []string{"example.com/m/foo"}, []string{"example.com/m/foo/bar", "example.com/m/foobar"}},
+		{"example.com/m/...", []string{"example.com/m", "example.com/m/foo", "example.com/m/foo/bar"}, []string{"example.com/n/foo"}},
+		{"*.Test*", []string{"example.com/m/foo.TestMain", "foo.T.TestField"}, []string{"example.com/m/foo.Tmp"}},
+		{"/Deprecated$/", []string{"foo.OldDeprecated"}, []string{"foo.Deprecated.F"}},
+	} {
+		re, err := compilePattern(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range test.match {
+			if !re.MatchString(s) {
+				t.Errorf("%s: expected %s to match", test.pattern, s)
+			}
+		}
+		for _, s := range test.nomatch {
+			if re.MatchString(s) {
+				t.Errorf("%s: expected %s not to match", test.pattern, s)
+			}
+		}
+	}
+}
+
+func TestProjectConfig(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.18\n",
+		".unexport.json": `{
+	"exclude": ["example.com/m/api/..."],
+	"exclude_identifiers": ["*.Test*"],
+	"kinds": ["func", "type", "field"],
+	"ignore_consumers": ["example.com/m/tools"],
+	"naming": {"example.com/m/...": {"URLParser": "urlParser", "T.ID": "id"}}
+}`,
+		"api/api.go": "package api\n\nfunc API() {}\n",
+		"foo/foo.go": `package foo
+
+type T struct {
+	ID int
+}
+
+func (T) M() {}
+
+type URLParser int
+
+func TestHelper() {}
+
+func Tool() {}
+
+const C = 1
+`,
+		"tools/tools.go": `package tools
+
+import "example.com/m/foo"
+
+var _ = foo.Tool
+`,
+	})
+	pc, err := FindProjectConfig(filepath.Join(dir, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc == nil || pc.Filename != filepath.Join(dir, ".unexport.json") {
+		t.Fatalf("expected the configuration of %s, got %+v", dir, pc)
+	}
+	conf := &Config{Dir: dir, Env: append(os.Environ(), "GOWORK=off", "GOFLAGS="), Project: pc}
+	u, err := Load(conf, "./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, o := range u.Report().Objects {
+		got = append(got, o.Qualifier+" "+o.ProposedName)
+		if o.Name == "Tool" && len(o.Conflicts) == 0 {
+			t.Errorf("expected a conflict with the ignored consumer")
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		`"example.com/m/foo".T t`,
+		`"example.com/m/foo".Tool tool`,
+		`"example.com/m/foo".URLParser urlParser`,
+		`("example.com/m/foo".T).ID id`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".unexport.json"), []byte(`{"kinds": ["struct"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindProjectConfig(dir); err == nil {
+		t.Errorf("expected an error for the invalid kind")
+	}
+}
@@ -0,0 +1,124 @@
+package unexport
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around the changes of a hunk
+const diffContext = 3
+
+// Diff writes the unified diff of renaming the identifiers, as Check last
+// checked them, for every file of the packages and their consumers, in the
+// format of git diff. The renaming runs in memory and is verified as by
+// Update, but no file is written. The files under root are named relative to
+// it, e.g. to apply the diff with git apply from the root of the repository.
+func (u *Unexporter) Diff(w io.Writer, root string, objs ...types.Object) error {
+	t, err := u.stage(u.renamesOf(objs...))
+	if err != nil {
+		return err
+	}
+	defer t.rollback()
+	var filenames []string
+	for filename := range t.files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		// the renamings written by the previous updates are in both versions
+		old, err := applyEdits(u.sources[filename], u.written[filename])
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		name := filepath.ToSlash(filename)
+		if rel, err := filepath.Rel(root, filename); root != "" && err == nil && !strings.HasPrefix(rel, "..") {
+			name = filepath.ToSlash(rel)
+		} else {
+			name = strings.TrimPrefix(name, "/")
+		}
+		if _, err := io.WriteString(w, unifiedDiff(name, old, t.files[filename])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unifiedDiff returns the unified diff of a file whose identifiers are
+// renamed, the renaming never adds nor removes a line, the changed lines
+// are compared one by one
+func unifiedDiff(name string, old, new []byte) string {
+	a, b := splitLines(old), splitLines(new)
+	if len(a) != len(b) {
+		// not a renaming, replace the whole file
+		return fmt.Sprintf("--- a/%s\n+++ b/%s\n@@ -1,%d +1,%d @@\n%s%s", name, name, len(a), len(b), diffLines("-", a), diffLines("+", b))
+	}
+	var changed []int
+	for i := range a {
+		if a[i] != b[i] {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- a/%s\n+++ b/%s\n", name, name)
+	for i := 0; i < len(changed); {
+		// the hunk extends as long as the next change is within its context
+		j := i + 1
+		for j < len(changed) && changed[j]-changed[j-1] <= 2*diffContext+1 {
+			j++
+		}
+		start, end := changed[i]-diffContext, changed[j-1]+diffContext+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(a) {
+			end = len(a)
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", start+1, end-start, start+1, end-start)
+		for k := start; k < end; {
+			if a[k] == b[k] {
+				buf.WriteString(diffLines(" ", a[k:k+1]))
+				k++
+				continue
+			}
+			l := k
+			for l < end && a[l] != b[l] {
+				l++
+			}
+			buf.WriteString(diffLines("-", a[k:l]))
+			buf.WriteString(diffLines("+", b[k:l]))
+			k = l
+		}
+		i = j
+	}
+	return buf.String()
+}
+
+// splitLines splits the content in lines, with their line terminators
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines prefixes the lines, the last line of a file may have no line
+// terminator
+func diffLines(prefix string, lines []string) string {
+	var buf strings.Builder
+	for _, line := range lines {
+		buf.WriteString(prefix + line)
+		if !strings.HasSuffix(line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,193 @@
+package unexport
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// keepDirective marks an identifier as API, kept exported even though no
+// other package refers to it, it's followed by an optional reason, e.g.
+//
+//	//unexport:keep used by the customers
+//	func Entrypoint() {}
+const keepDirective = "//unexport:keep"
+
+// StaleDirective is a //unexport:keep directive that is no longer needed
+type StaleDirective struct {
+	Pos     token.Position
+	Message string
+}
+
+func (d StaleDirective) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+// directive is a //unexport:keep directive, with the identifiers it applies to
+type directive struct {
+	comment *ast.Comment
+	reason  string
+	objs    []types.Object // nil if it's not attached to a declaration
+}
+
+// directives finds the //unexport:keep directives of the packages to
+// unexport, they are attached to the doc comment or the line comment of
+// a declaration, a struct field or an interface method, the directive of
+// a grouped declaration applies to every identifier of the group.
+func (u *Unexporter) directives() []*directive {
+	var directives []*directive
+	for path, info := range u.iprog.Imported {
+		if !u.paths[path] {
+			continue
+		}
+		for _, f := range info.Files {
+			byComment := make(map[*ast.Comment]*directive)
+			for _, cg := range f.Comments {
+				for _, c := range cg.List {
+					if c.Text == keepDirective || strings.HasPrefix(c.Text, keepDirective+" ") {
+						d := &directive{comment: c, reason: strings.TrimSpace(strings.TrimPrefix(c.Text, keepDirective))}
+						byComment[c] = d
+						directives = append(directives, d)
+					}
+				}
+			}
+			if len(byComment) == 0 {
+				continue
+			}
+			attach := func(ids []*ast.Ident, cgs ...*ast.CommentGroup) {
+				for _, cg := range cgs {
+					if cg == nil {
+						continue
+					}
+					for _, c := range cg.List {
+						d := byComment[c]
+						if d == nil {
+							continue
+						}
+						for _, id := range ids {
+							if obj := info.Defs[id]; obj != nil {
+								d.objs = append(d.objs, obj)
+							}
+						}
+					}
+				}
+			}
+			for _, decl := range f.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					attach([]*ast.Ident{decl.Name}, decl.Doc)
+				case *ast.GenDecl:
+					var all []*ast.Ident
+					for _, spec := range decl.Specs {
+						switch spec := spec.(type) {
+						case *ast.TypeSpec:
+							all = append(all, spec.Name)
+							attach([]*ast.Ident{spec.Name}, spec.Doc, spec.Comment)
+							attachFields(spec.Type, attach)
+						case *ast.ValueSpec:
+							all = append(all, spec.Names...)
+							attach(spec.Names, spec.Doc, spec.Comment)
+						}
+					}
+					attach(all, decl.Doc)
+				}
+			}
+		}
+	}
+	sort.Slice(directives, func(i, j int) bool {
+		return directives[i].comment.Pos() < directives[j].comment.Pos()
+	})
+	return directives
+}
+
+// attachFields attaches the directives of the fields and the interface methods of t
+func attachFields(t ast.Expr, attach func([]*ast.Ident, ...*ast.CommentGroup)) {
+	ast.Inspect(t, func(n ast.Node) bool {
+		var fields *ast.FieldList
+		switch n := n.(type) {
+		case *ast.StructType:
+			fields = n.Fields
+		case *ast.InterfaceType:
+			fields = n.Methods
+		case *ast.FuncType:
+			// the parameters are not fields
+			return false
+		default:
+			return true
+		}
+		for _, field := range fields.List {
+			names := field.Names
+			if len(names) == 0 {
+				// the embedded field is defined by the identifier of its type
+				if id := typeIdent(field.Type); id != nil {
+					names = []*ast.Ident{id}
+				}
+			}
+			attach(names, field.Doc, field.Comment)
+		}
+		return true
+	})
+}
+
+// typeIdent returns the identifier of an embedded type, e.g. T of *pkg.T[int]
+func typeIdent(x ast.Expr) *ast.Ident {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x
+	case *ast.StarExpr:
+		return typeIdent(x.X)
+	case *ast.SelectorExpr:
+		return x.Sel
+	case *ast.IndexExpr:
+		return typeIdent(x.X)
+	case *ast.IndexListExpr:
+		return typeIdent(x.X)
+	}
+	return nil
+}
+
+// keepDirectives keeps the identifiers marked by a //unexport:keep directive
+func (u *Unexporter) keepDirectives(keep func(types.Object, string)) {
+	for _, d := range u.directives() {
+		reason := fmt.Sprintf("marked by %s at %s", keepDirective, u.iprog.Fset.Position(d.comment.Pos()))
+		if d.reason != "" {
+			reason += ": " + d.reason
+		}
+		for _, obj := range d.objs {
+			keep(obj, reason)
+		}
+	}
+}
+
+// staleDirectives returns the //unexport:keep directives that are not
+// needed, as they are not attached to an exported declaration, or every
+// identifier they apply to is used by other packages
+func (u *Unexporter) staleDirectives(used map[types.Object]bool) []StaleDirective {
+	var stale []StaleDirective
+	for _, d := range u.directives() {
+		var message string
+		if len(d.objs) == 0 {
+			message = keepDirective + " is not attached to a declaration"
+		}
+		for _, obj := range d.objs {
+			switch {
+			case !obj.Exported():
+				message = fmt.Sprintf("%s is not exported", obj.Name())
+			case used[obj]:
+				message = fmt.Sprintf("%s is used by other packages", obj.Name())
+			default:
+				message = ""
+			}
+			if message == "" {
+				break
+			}
+		}
+		if message != "" {
+			stale = append(stale, StaleDirective{Pos: u.iprog.Fset.Position(d.comment.Pos()), Message: message})
+		}
+	}
+	return stale
+}
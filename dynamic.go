@@ -0,0 +1,261 @@
+package unexport
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// Interface is an interface whose methods are called dynamically, on the values
+// converted to an interface type and found by a type assertion, e.g. the String
+// method called by fmt.Println.
+type Interface struct {
+	// Name is the qualified name of the interface, e.g. "fmt.Stringer"
+	Name string
+	// Methods are the signatures of the methods, with the types qualified by
+	// their package path, e.g. "ServeHTTP(net/http.ResponseWriter, *net/http.Request)"
+	Methods []string
+}
+
+// DefaultInterfaces are the interfaces of the standard library checked by
+// a type assertion, used if Config.Interfaces is nil
+var DefaultInterfaces = []Interface{
+	{"error", []string{"Error() string"}},
+	{"fmt.Stringer", []string{"String() string"}},
+	{"fmt.GoStringer", []string{"GoString() string"}},
+	{"fmt.Formatter", []string{"Format(fmt.State, rune)"}},
+	{"encoding.TextMarshaler", []string{"MarshalText() ([]byte, error)"}},
+	{"encoding.TextUnmarshaler", []string{"UnmarshalText([]byte) error"}},
+	{"encoding.BinaryMarshaler", []string{"MarshalBinary() ([]byte, error)"}},
+	{"encoding.BinaryUnmarshaler", []string{"UnmarshalBinary([]byte) error"}},
+	{"encoding/json.Marshaler", []string{"MarshalJSON() ([]byte, error)"}},
+	{"encoding/json.Unmarshaler", []string{"UnmarshalJSON([]byte) error"}},
+	{"encoding/xml.Marshaler", []string{"MarshalXML(*encoding/xml.Encoder, encoding/xml.StartElement) error"}},
+	{"encoding/xml.Unmarshaler", []string{"UnmarshalXML(*encoding/xml.Decoder, encoding/xml.StartElement) error"}},
+	{"encoding/gob.GobEncoder", []string{"GobEncode() ([]byte, error)"}},
+	{"encoding/gob.GobDecoder", []string{"GobDecode([]byte) error"}},
+	{"gopkg.in/yaml.v3.Marshaler", []string{"MarshalYAML() (any, error)"}},
+	{"gopkg.in/yaml.v3.Unmarshaler", []string{"UnmarshalYAML(*gopkg.in/yaml.v3.Node) error"}},
+	{"io.WriterTo", []string{"WriteTo(io.Writer) (int64, error)"}},
+	{"io.ReaderFrom", []string{"ReadFrom(io.Reader) (int64, error)"}},
+	{"net/http.Handler", []string{"ServeHTTP(net/http.ResponseWriter, *net/http.Request)"}},
+}
+
+// keepDynamic keeps the methods of the interfaces called dynamically, if the
+// type implementing the interface is converted to an interface type, e.g. the
+// String method of a value passed to fmt.Println. The conversions to the
+// non-empty interfaces are covered as well, e.g. io.Copy checks if its
+// io.Reader is an io.WriterTo.
+func (u *Unexporter) keepDynamic(keep func(types.Object, string)) {
+	seen := make(map[types.Type]bool)
+	for _, info := range u.packages {
+		for _, f := range info.Files {
+			interfaceConversions(&info.Info, f, func(t, iface types.Type, pos token.Pos) {
+				if types.IsInterface(t) || seen[t] {
+					return
+				}
+				seen[t] = true
+				mset := u.msets.MethodSet(t)
+				for _, dyn := range u.interfaces {
+					methods := implements(mset, dyn)
+					for _, m := range methods {
+						if m.Exported() {
+							keep(m, fmt.Sprintf("implements %s, and %s is converted to %s at %s",
+								dyn.Name, t, iface, u.iprog.Fset.Position(pos)))
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// implements returns the methods of mset implementing the interface, nil if
+// any method is missing
+func implements(mset *types.MethodSet, iface Interface) []types.Object {
+	var methods []types.Object
+	for _, sig := range iface.Methods {
+		name := sig
+		if i := strings.Index(sig, "("); i >= 0 {
+			name = sig[:i]
+		}
+		sel := mset.Lookup(nil, name)
+		if sel == nil {
+			return nil
+		}
+		fn, ok := sel.Obj().(*types.Func)
+		if !ok || methodSignature(fn) != normalizeSignature(sig) {
+			return nil
+		}
+		methods = append(methods, fn.Origin())
+	}
+	return methods
+}
+
+// methodSignature returns the signature of a method, in the form of
+// Interface.Methods
+func methodSignature(fn *types.Func) string {
+	sig := fn.Type().(*types.Signature)
+	qualifier := func(pkg *types.Package) string { return pkg.Path() }
+	tuple := func(t *types.Tuple, variadic bool) []string {
+		var s []string
+		for i := 0; i < t.Len(); i++ {
+			typ := t.At(i).Type()
+			if variadic && i == t.Len()-1 {
+				s = append(s, "..."+types.TypeString(typ.(*types.Slice).Elem(), qualifier))
+			} else {
+				s = append(s, types.TypeString(typ, qualifier))
+			}
+		}
+		return s
+	}
+	s := fn.Name() + "(" + strings.Join(tuple(sig.Params(), sig.Variadic()), ", ") + ")"
+	switch results := tuple(sig.Results(), false); len(results) {
+	case 0:
+	case 1:
+		s += " " + results[0]
+	default:
+		s += " (" + strings.Join(results, ", ") + ")"
+	}
+	return normalizeSignature(s)
+}
+
+// normalizeSignature spells the empty interface any
+func normalizeSignature(sig string) string {
+	return strings.ReplaceAll(sig, "interface{}", "any")
+}
+
+// interfaceConversions calls found with the type of every value of f
+// converted to an interface type, implicitly or not. Unlike satisfy.Finder,
+// the conversions to the empty interface are included.
+func interfaceConversions(info *types.Info, f *ast.File, found func(t, iface types.Type, pos token.Pos)) {
+	convert := func(x ast.Expr, iface types.Type) {
+		if iface == nil || !types.IsInterface(iface) {
+			return
+		}
+		tv, ok := info.Types[x]
+		if !ok || tv.Type == nil || tv.IsNil() {
+			return
+		}
+		found(types.Default(tv.Type), iface, x.Pos())
+	}
+	var results []*types.Tuple // of the enclosing functions
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+			var typ types.Type
+			var body *ast.BlockStmt
+			switch fn := n.(type) {
+			case *ast.FuncDecl:
+				if obj := info.Defs[fn.Name]; obj != nil {
+					typ = obj.Type()
+				}
+				body = fn.Body
+			case *ast.FuncLit:
+				typ, body = info.TypeOf(fn), fn.Body
+			}
+			sig, ok := typ.(*types.Signature)
+			if !ok || body == nil {
+				return true
+			}
+			results = append(results, sig.Results())
+			ast.Inspect(body, visit)
+			results = results[:len(results)-1]
+			return false
+		case *ast.CallExpr:
+			tv, ok := info.Types[n.Fun]
+			if !ok {
+				return true
+			}
+			if tv.IsType() {
+				if len(n.Args) == 1 {
+					convert(n.Args[0], tv.Type)
+				}
+				return true
+			}
+			sig, ok := tv.Type.Underlying().(*types.Signature)
+			if !ok {
+				return true
+			}
+			params := sig.Params()
+			for i, arg := range n.Args {
+				switch {
+				case sig.Variadic() && i >= params.Len()-1:
+					last := params.At(params.Len() - 1).Type()
+					if n.Ellipsis.IsValid() {
+						convert(arg, last)
+					} else if s, ok := last.(*types.Slice); ok {
+						convert(arg, s.Elem())
+					}
+				case i < params.Len():
+					convert(arg, params.At(i).Type())
+				}
+			}
+		case *ast.AssignStmt:
+			if n.Tok == token.ASSIGN && len(n.Lhs) == len(n.Rhs) {
+				for i, lhs := range n.Lhs {
+					convert(n.Rhs[i], info.TypeOf(lhs))
+				}
+			}
+		case *ast.ValueSpec:
+			if n.Type != nil && len(n.Names) == len(n.Values) {
+				for _, v := range n.Values {
+					convert(v, info.TypeOf(n.Type))
+				}
+			}
+		case *ast.ReturnStmt:
+			if len(results) > 0 && results[len(results)-1].Len() == len(n.Results) {
+				for i, x := range n.Results {
+					convert(x, results[len(results)-1].At(i).Type())
+				}
+			}
+		case *ast.SendStmt:
+			// the channel has no type if it fails to type-check
+			if t := info.TypeOf(n.Chan); t != nil {
+				if ch, ok := t.Underlying().(*types.Chan); ok {
+					convert(n.Value, ch.Elem())
+				}
+			}
+		case *ast.CompositeLit:
+			t := info.TypeOf(n)
+			if t == nil {
+				return true
+			}
+			for i, elt := range n.Elts {
+				kv, _ := elt.(*ast.KeyValueExpr)
+				switch t := deref(t).Underlying().(type) {
+				case *types.Struct:
+					if kv != nil {
+						if id, ok := kv.Key.(*ast.Ident); ok {
+							if obj := info.Uses[id]; obj != nil {
+								convert(kv.Value, obj.Type())
+							}
+						}
+					} else if i < t.NumFields() {
+						convert(elt, t.Field(i).Type())
+					}
+				case *types.Map:
+					if kv != nil {
+						convert(kv.Key, t.Key())
+						convert(kv.Value, t.Elem())
+					}
+				case *types.Slice:
+					if kv != nil {
+						elt = kv.Value
+					}
+					convert(elt, t.Elem())
+				case *types.Array:
+					if kv != nil {
+						elt = kv.Value
+					}
+					convert(elt, t.Elem())
+				}
+			}
+		}
+		return true
+	}
+	ast.Inspect(f, visit)
+}
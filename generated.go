@@ -0,0 +1,78 @@
+package unexport
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+)
+
+// GeneratedPolicy tells how the uses of an identifier from generated files are considered
+type GeneratedPolicy int
+
+const (
+	// GeneratedUsesKeep counts the uses from generated files as real uses, the identifier stays exported
+	GeneratedUsesKeep GeneratedPolicy = iota
+	// GeneratedUsesIgnore ignores the uses from generated files, the identifier
+	// is reported as unused, with a conflict as the generated files are not rewritten
+	GeneratedUsesIgnore
+)
+
+// findGenerated finds the generated files, the ones with the standard
+// "// Code generated ... DO NOT EDIT." comment, and the identifiers they
+// refer to. The generated files are never rewritten, the next go generate
+// would revert the renaming.
+func (u *Unexporter) findGenerated() {
+	u.generated = make(map[string]bool)
+	u.generatedRefs = make(map[types.Object][]token.Pos)
+	for _, info := range u.packages {
+		for _, f := range info.Files {
+			if !ast.IsGenerated(f) {
+				continue
+			}
+			u.generated[u.iprog.Fset.File(f.Pos()).Name()] = true
+		}
+	}
+	if len(u.generated) == 0 {
+		return
+	}
+	seen := make(map[token.Pos]bool)
+	add := func(id *ast.Ident, obj types.Object) {
+		if obj == nil || obj.Pkg() == nil || !id.IsExported() || seen[id.Pos()] || !u.isGenerated(id.Pos()) {
+			return
+		}
+		// the test variants of a package share the syntax trees
+		seen[id.Pos()] = true
+		obj = u.canonical(obj)
+		u.generatedRefs[obj] = append(u.generatedRefs[obj], id.Pos())
+	}
+	for _, info := range u.packages {
+		for id, obj := range info.Defs {
+			add(id, obj)
+		}
+		for id, obj := range info.Uses {
+			add(id, obj)
+		}
+	}
+}
+
+// isGenerated reports whether pos is in a generated file, the //line
+// directives of the generators are not followed
+func (u *Unexporter) isGenerated(pos token.Pos) bool {
+	return u.generated[u.iprog.Fset.PositionFor(pos, false).Filename]
+}
+
+// checkGenerated reports a conflict per generated file that would be edited
+// by the renaming of from
+func (r *Unexporter) checkGenerated(from types.Object, to string) {
+	reported := make(map[string]bool)
+	for _, pos := range r.generatedRefs[r.canonical(from)] {
+		filename := r.iprog.Fset.PositionFor(pos, false).Filename
+		if reported[filename] {
+			continue
+		}
+		reported[filename] = true
+		r.warn(from, r.errorf(pos, "renaming this %s %q to %q would edit the generated file %s",
+			objectKind(from), from.Name(), to, filepath.Base(filename)))
+	}
+}
@@ -0,0 +1,245 @@
+package unexport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JournalFile is the default name of the journal of the applied renamings,
+// see Config.Journal
+const JournalFile = ".unexport.journal"
+
+// Operation is a renaming applied by Update, UpdateAll or ApplyPlan, as it's
+// recorded in the journal, a JSON object per line
+type Operation struct {
+	Time      time.Time      `json:"time"`
+	Renamings []RenameRecord `json:"renamings"`
+	Files     []FileRecord   `json:"files"`
+}
+
+// RenameRecord is the renaming of an identifier
+type RenameRecord struct {
+	Qualifier string `json:"qualifier"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// FileRecord is the change of a file, with the SHA-256 of its content before
+// and after the renaming
+type FileRecord struct {
+	Filename string `json:"filename"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+	// Edits are the renamed identifiers, at their offsets in the content after
+	Edits []TextEdit `json:"edits"`
+}
+
+// TextEdit is the replacement of an identifier
+type TextEdit struct {
+	Offset int    `json:"offset"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+func (op Operation) String() string {
+	var renamings []string
+	for _, r := range op.Renamings {
+		renamings = append(renamings, r.Qualifier+" to "+r.To)
+	}
+	return fmt.Sprintf("renaming %s, in %d file%s", strings.Join(renamings, ", "), len(op.Files), plural(len(op.Files)))
+}
+
+// ReadJournal reads the operations of the journal, oldest first, there are
+// none if the journal doesn't exist
+func ReadJournal(filename string) ([]Operation, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ops []Operation
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var op Operation
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		ops = append(ops, op)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return ops, nil
+}
+
+// appendJournal records an operation at the end of the journal
+func appendJournal(filename string, op Operation) error {
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeJournal replaces the operations of the journal
+func writeJournal(filename string, ops []Operation) error {
+	var buf bytes.Buffer
+	for _, op := range ops {
+		b, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// Undo reverts the last n operations of the journal, latest first, and removes
+// them from the journal. An operation is reverted only if its files have not
+// changed since, otherwise Undo stops and reports the conflict. It returns the
+// operations reverted.
+func Undo(journal string, n int) ([]Operation, error) {
+	ops, err := ReadJournal(journal)
+	if err != nil {
+		return nil, err
+	}
+	if n > len(ops) {
+		return nil, fmt.Errorf("cannot undo %d operation%s, %d recorded in %s", n, plural(n), len(ops), journal)
+	}
+	var reverted []Operation
+	for i := len(ops) - 1; i >= len(ops)-n; i-- {
+		op := ops[i]
+		files := make(map[string][]byte)
+		for _, fr := range op.Files {
+			content, err := ioutil.ReadFile(fr.Filename)
+			if err != nil {
+				return reverted, fmt.Errorf("cannot undo the %s: %v", op, err)
+			}
+			if contentHash(content) != fr.After {
+				return reverted, fmt.Errorf("conflict: cannot undo the %s, %s has changed since", op, fr.Filename)
+			}
+			orig, err := revertEdits(content, fr.Edits)
+			if err != nil || contentHash(orig) != fr.Before {
+				return reverted, fmt.Errorf("cannot undo the %s, the edits of %s don't match its content", op, fr.Filename)
+			}
+			files[fr.Filename] = orig
+		}
+		if err := writeFiles(files); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, op)
+		if err := writeJournal(journal, ops[:i]); err != nil {
+			return reverted, err
+		}
+	}
+	return reverted, nil
+}
+
+// operation describes the renaming of objs applied by the transaction, the
+// files are the ones staged, before is the renamings written before
+func (u *Unexporter) operation(t *transaction, objs []types.Object, before map[string]map[int]string) Operation {
+	op := Operation{Time: time.Now()}
+	for _, obj := range objs {
+		op.Renamings = append(op.Renamings, RenameRecord{Qualifier: u.Qualifier(obj), From: obj.Name(), To: u.newName(obj)})
+	}
+	sort.Slice(op.Renamings, func(i, j int) bool {
+		return op.Renamings[i].Qualifier < op.Renamings[j].Qualifier
+	})
+	var filenames []string
+	for filename := range t.files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		src := u.sources[filename]
+		// applied to the same file, the renamings can't fail again
+		old, _ := applyEdits(src, before[filename])
+		op.Files = append(op.Files, FileRecord{
+			Filename: filename,
+			Before:   contentHash(old),
+			After:    contentHash(t.files[filename]),
+			Edits:    textEdits(src, before[filename], t.edits[filename]),
+		})
+	}
+	return op
+}
+
+// textEdits returns the replacements turning src renamed by before into src
+// renamed by after, at their offsets in the latter
+func textEdits(src []byte, before, after map[int]string) []TextEdit {
+	var offsets []int
+	for offset := range after {
+		offsets = append(offsets, offset)
+	}
+	for offset := range before {
+		if _, ok := after[offset]; !ok {
+			offsets = append(offsets, offset)
+		}
+	}
+	sort.Ints(offsets)
+	var edits []TextEdit
+	delta := 0
+	for _, offset := range offsets {
+		name := string(src[offset:identEnd(src, offset)])
+		old, ok := before[offset]
+		if !ok {
+			old = name
+		}
+		new, ok := after[offset]
+		if !ok {
+			new = name
+		}
+		if old != new {
+			edits = append(edits, TextEdit{Offset: offset + delta, Old: old, New: new})
+		}
+		delta += len(new) - len(name)
+	}
+	return edits
+}
+
+// revertEdits replaces the new identifiers by the old ones
+func revertEdits(content []byte, edits []TextEdit) ([]byte, error) {
+	var buf bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		end := e.Offset + len(e.New)
+		if e.Offset < last || end > len(content) || string(content[e.Offset:end]) != e.New {
+			return nil, fmt.Errorf("no %s at offset %d", e.New, e.Offset)
+		}
+		buf.Write(content[last:e.Offset])
+		buf.WriteString(e.Old)
+		last = end
+	}
+	buf.Write(content[last:])
+	return buf.Bytes(), nil
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,60 @@
+package unexport
+
+import (
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUndo(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod":     "module example.com/m\n\ngo 1.18\n",
+		"foo/foo.go": "package foo\n\nfunc F() {}\n\nfunc G() { F() }\n",
+	})
+	foo := filepath.Join(dir, "foo", "foo.go")
+	journal := filepath.Join(dir, JournalFile)
+	u, err := Load(&Config{Dir: dir, Env: append(os.Environ(), "GOWORK=off", "GOFLAGS="), Journal: journal}, "./foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := make(map[string]types.Object)
+	for _, obj := range u.UnusedObjectsSorted() {
+		objs[obj.Name()] = obj
+	}
+	for _, name := range []string{"F", "G"} {
+		if err := u.Update(objs[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ops, err := ReadJournal(journal)
+	if err != nil || len(ops) != 2 {
+		t.Fatalf("expected 2 operations, got %v %v", ops, err)
+	}
+	if op := ops[1].Renamings[0]; op.From != "G" || op.To != "g" {
+		t.Errorf("expected the renaming of G to g, got %v", op)
+	}
+
+	if _, err := Undo(journal, 1); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(foo); string(content) != "package foo\n\nfunc f() {}\n\nfunc G() { f() }\n" {
+		t.Errorf("expected the renaming of G to be reverted, got\n%s", content)
+	}
+
+	// changed since
+	changed := "package foo\n\nfunc f() {}\n\nfunc G() { f(); f() }\n"
+	if err := os.WriteFile(foo, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Undo(journal, 1); err == nil || !strings.Contains(err.Error(), "conflict") {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	if content, _ := os.ReadFile(foo); string(content) != changed {
+		t.Errorf("expected %s to be unchanged, got\n%s", foo, content)
+	}
+	if ops, _ := ReadJournal(journal); len(ops) != 1 {
+		t.Errorf("expected 1 operation left, got %v", ops)
+	}
+}
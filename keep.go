@@ -0,0 +1,168 @@
+package unexport
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// keptObjects returns the exported identifiers that must stay exported, even
+// though no other package refers to them, with the reason, e.g. the fields
+// encoded or looked up by reflection, the methods called dynamically, or the
+// functions referred to by the assembly files. The reason of the
+// //unexport:keep directives comes first.
+func (u *Unexporter) keptObjects() map[types.Object]string {
+	kept := make(map[types.Object]string)
+	keep := func(obj types.Object, reason string) {
+		obj = u.canonical(obj)
+		if _, ok := kept[obj]; !ok {
+			kept[obj] = reason
+		}
+	}
+	u.keepDirectives(keep)
+	u.keepSerialized(keep)
+	u.keepLookedUp(keep)
+	u.keepDynamic(keep)
+	u.keepNonGo(keep)
+	return kept
+}
+
+// keepRule returns the reason of the first keep rule deciding to keep obj exported
+func (u *Unexporter) keepRule(obj types.Object) string {
+	if len(u.keepRules) == 0 {
+		return ""
+	}
+	if u.loadedPackages == nil {
+		for pkg := range u.packages {
+			u.loadedPackages = append(u.loadedPackages, pkg)
+		}
+		sort.Slice(u.loadedPackages, func(i, j int) bool {
+			return u.loadedPackages[i].Path() < u.loadedPackages[j].Path()
+		})
+	}
+	for _, rule := range u.keepRules {
+		if reason := rule.Keep(obj, u.loadedPackages); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// serializationTags are the struct tag keys of the encoders
+var serializationTags = []string{"json", "xml", "yaml"}
+
+// encoders are the functions encoding or decoding their argument by reflection,
+// by types.Func.FullName, with the index of the argument
+var encoders = map[string]int{
+	"encoding/json.Marshal":                 0,
+	"encoding/json.MarshalIndent":           0,
+	"encoding/json.Unmarshal":               1,
+	"(*encoding/json.Encoder).Encode":       0,
+	"(*encoding/json.Decoder).Decode":       0,
+	"encoding/xml.Marshal":                  0,
+	"encoding/xml.MarshalIndent":            0,
+	"encoding/xml.Unmarshal":                1,
+	"(*encoding/xml.Encoder).Encode":        0,
+	"(*encoding/xml.Encoder).EncodeElement": 0,
+	"(*encoding/xml.Decoder).Decode":        0,
+	"(*encoding/xml.Decoder).DecodeElement": 0,
+	"(*encoding/gob.Encoder).Encode":        0,
+	"(*encoding/gob.Decoder).Decode":        0,
+	"encoding/gob.Register":                 0,
+	"encoding/gob.RegisterName":             1,
+}
+
+func init() {
+	for _, path := range []string{"gopkg.in/yaml.v2", "gopkg.in/yaml.v3", "sigs.k8s.io/yaml"} {
+		encoders[path+".Marshal"] = 0
+		encoders[path+".Unmarshal"] = 1
+		encoders["(*"+path+".Encoder).Encode"] = 0
+		encoders["(*"+path+".Decoder).Decode"] = 0
+	}
+}
+
+// keepSerialized keeps the exported fields with a struct tag of an encoder, and
+// the exported fields of the values passed to the encoders, as renaming them
+// changes or drops the encoded fields.
+func (u *Unexporter) keepSerialized(keep func(types.Object, string)) {
+	for _, info := range u.packages {
+		for _, f := range info.Files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.Field:
+					if n.Tag == nil {
+						return true
+					}
+					tag, err := strconv.Unquote(n.Tag.Value)
+					if err != nil {
+						return true
+					}
+					for _, key := range serializationTags {
+						if name, ok := reflect.StructTag(tag).Lookup(key); ok && name != "-" {
+							for _, id := range n.Names {
+								if obj := info.Defs[id]; obj != nil && id.IsExported() {
+									keep(obj, fmt.Sprintf("has the struct tag %s:%q", key, name))
+								}
+							}
+							break
+						}
+					}
+				case *ast.CallExpr:
+					fn, ok := typeutil.Callee(&info.Info, n).(*types.Func)
+					if !ok {
+						return true
+					}
+					i, ok := encoders[fn.FullName()]
+					if !ok || i >= len(n.Args) {
+						return true
+					}
+					tv, ok := info.Types[n.Args[i]]
+					if !ok {
+						return true
+					}
+					reason := fmt.Sprintf("serialized by %s at %s", fn.FullName(), u.iprog.Fset.Position(n.Pos()))
+					keepFields(tv.Type, reason, keep, make(map[types.Type]bool))
+				}
+				return true
+			})
+		}
+	}
+}
+
+// keepFields keeps the exported fields reachable from a value of type t
+func keepFields(t types.Type, reason string, keep func(types.Object, string), seen map[types.Type]bool) {
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	switch t := t.(type) {
+	case *types.Named:
+		keepFields(t.Underlying(), reason, keep, seen)
+	case *types.Pointer:
+		keepFields(t.Elem(), reason, keep, seen)
+	case *types.Slice:
+		keepFields(t.Elem(), reason, keep, seen)
+	case *types.Array:
+		keepFields(t.Elem(), reason, keep, seen)
+	case *types.Map:
+		keepFields(t.Key(), reason, keep, seen)
+		keepFields(t.Elem(), reason, keep, seen)
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			// the field of an instantiated generic type is declared by its origin
+			field := t.Field(i).Origin()
+			// the fields of the embedded structs are promoted, exported or not
+			if field.Exported() {
+				keep(field, reason)
+			} else if !field.Embedded() {
+				continue
+			}
+			keepFields(t.Field(i).Type(), reason, keep, seen)
+		}
+	}
+}
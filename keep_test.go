@@ -0,0 +1,461 @@
+package unexport
+
+import (
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeJSON is the subset of encoding/json used by the tests
+const fakeJSON = `package json
+func Marshal(v interface{}) ([]byte, error) { return nil, nil }
+type Encoder struct{}
+func (*Encoder) Encode(v interface{}) error { return nil }
+`
+
+func TestKeepSerialized(t *testing.T) {
+	for _, test := range []struct {
+		src    string
+		unused []string
+		kept   map[string]string // qualifier to the prefix of the reason
+	}{
+		// struct tags
+		{
+			src:    "package main\ntype T struct {\nA int `json:\"a,omitempty\"`\nB int `json:\"-\"`\nC int `yaml:\"c\"`\n}\n",
+			unused: []string{`"main".T`, `("main".T).B`},
+			kept: map[string]string{
+				`("main".T).A`: `has the struct tag json:"a,omitempty"`,
+				`("main".T).C`: `has the struct tag yaml:"c"`,
+			},
+		},
+		// values passed to the encoders, the fields are reachable through
+		// pointers, slices, maps and embedded structs
+		{
+			src: `package main
+import "encoding/json"
+type T struct {
+	A int
+	N []Nested
+	m map[string]*Nested
+	embedded
+}
+type Nested struct {
+	B int
+}
+type embedded struct {
+	C int
+}
+type U struct {
+	D int
+}
+func main() {
+	json.Marshal(&T{})
+	new(json.Encoder).Encode(map[string]U{})
+}
+`,
+			unused: []string{`"main".Nested`, `"main".T`, `"main".U`},
+			kept: map[string]string{
+				`("main".T).A`:        "serialized by encoding/json.Marshal at /go/src/main/0.go:19:2",
+				`("main".T).N`:        "serialized by encoding/json.Marshal",
+				`("main".Nested).B`:   "serialized by encoding/json.Marshal",
+				`("main".embedded).C`: "serialized by encoding/json.Marshal",
+				`("main".U).D`:        "serialized by (*encoding/json.Encoder).Encode",
+			},
+		},
+	} {
+		u, err := New(fakeContext(map[string][]string{
+			"main":          {test.src},
+			"encoding/json": {fakeJSON},
+		}), "main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkKept(t, u, test.unused, test.kept)
+	}
+}
+
+// fakeReflect and fakeTemplate are the subsets of reflect and text/template used by the tests
+const (
+	fakeReflect = `package reflect
+type Value struct{}
+func ValueOf(i interface{}) Value { return Value{} }
+func (Value) Elem() Value { return Value{} }
+func (Value) MethodByName(string) Value { return Value{} }
+func (Value) FieldByName(string) Value { return Value{} }
+`
+	fakeTemplate = `package template
+type Template struct{}
+func New(name string) *Template { return nil }
+func Must(t *Template, err error) *Template { return t }
+func (t *Template) Parse(text string) (*Template, error) { return t, nil }
+func ParseFiles(filenames ...string) (*Template, error) { return nil, nil }
+`
+)
+
+func TestKeepLookedUp(t *testing.T) {
+	for _, test := range []struct {
+		src    string
+		unused []string
+		kept   map[string]string // qualifier to the prefix of the reason
+	}{
+		// the type of the value is known
+		{
+			src: `package main
+import "reflect"
+type T struct{}
+func (T) Foo() {}
+func (T) Bar() {}
+type U struct{}
+func (U) Foo() {}
+func main() {
+	reflect.ValueOf(&T{}).Elem().MethodByName("Foo")
+}
+`,
+			unused: []string{`"main".T`, `"main".U`, `("main".T).Bar`, `("main".U).Foo`},
+			kept: map[string]string{
+				`("main".T).Foo`: `looked up by (reflect.Value).MethodByName("Foo") at /go/src/main/0.go:9:2`,
+			},
+		},
+		// every field with the name
+		{
+			src: `package main
+import "reflect"
+type T struct {
+	A, B int
+}
+type U struct {
+	A int
+}
+func f(v interface{}) {
+	reflect.ValueOf(v).FieldByName("A")
+}
+`,
+			unused: []string{`"main".T`, `"main".U`, `("main".T).B`},
+			kept: map[string]string{
+				`("main".T).A`: `looked up by (reflect.Value).FieldByName("A")`,
+				`("main".U).A`: `looked up by (reflect.Value).FieldByName("A")`,
+			},
+		},
+		// template text
+		{
+			src: `package main
+import "text/template"
+type T struct {
+	Name  string
+	Items []Item
+}
+type Item struct {
+	Title  string
+	Hidden bool
+}
+func (Item) Upper() string { return "" }
+var t = template.Must(template.New("t").Parse(` + "`" + `{{.Name}}
+{{range $i, $item := .Items}}{{$item.Title}} {{.Upper | printf "%s"}}{{end}}` + "`" + `))
+`,
+			unused: []string{`"main".Item`, `"main".T`, `("main".Item).Hidden`},
+			kept: map[string]string{
+				`("main".T).Name`:     "referenced by the template at /go/src/main/0.go:12:47",
+				`("main".T).Items`:    "referenced by the template",
+				`("main".Item).Title`: "referenced by the template",
+				`("main".Item).Upper`: "referenced by the template",
+			},
+		},
+	} {
+		u, err := New(fakeContext(map[string][]string{
+			"main":          {test.src},
+			"reflect":       {fakeReflect},
+			"text/template": {fakeTemplate},
+		}), "main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkKept(t, u, test.unused, test.kept)
+	}
+}
+
+func TestKeepTemplateFiles(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.18\n",
+		"page/page.go": `package page
+import "html/template"
+type Page struct {
+	Title string
+	Body  string
+}
+var t = template.Must(template.ParseFiles("page.tmpl"))
+`,
+		"page/page.tmpl": "<h1>{{.Title}}</h1>\n<p>{{.Body}}</p>\n",
+	})
+	u, err := Load(&Config{Dir: dir, Env: append(os.Environ(), "GOWORK=off", "GOFLAGS=")}, "./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := filepath.Join(dir, "page", "page.tmpl")
+	checkKept(t, u, []string{`"example.com/m/page".Page`}, map[string]string{
+		`("example.com/m/page".Page).Title`: "referenced by the template at " + tmpl + ":1",
+		`("example.com/m/page".Page).Body`:  "referenced by the template at " + tmpl + ":2",
+	})
+}
+
+// checkKept checks the unused identifiers, and the reasons of the kept ones
+func checkKept(t *testing.T, u *Unexporter, wantUnused []string, wantKept map[string]string) {
+	t.Helper()
+	var unused []string
+	for _, obj := range u.UnusedObjectsSorted() {
+		unused = append(unused, u.Qualifier(obj))
+	}
+	sort.Strings(unused)
+	if !reflect.DeepEqual(unused, wantUnused) {
+		t.Errorf("expected the unused identifiers %v, got %v", wantUnused, unused)
+	}
+	kept := make(map[string]string)
+	for _, k := range u.Report().Kept {
+		kept[k.Qualifier] = k.Reason
+	}
+	if len(kept) != len(wantKept) {
+		t.Errorf("expected the kept identifiers %v, got %v", wantKept, kept)
+	}
+	for q, reason := range wantKept {
+		if !strings.HasPrefix(kept[q], reason) {
+			t.Errorf("expected %s to be kept as %s, got %q", q, reason, kept[q])
+		}
+	}
+}
+
+// fakeFmt is the subset of fmt used by the tests
+const fakeFmt = `package fmt
+type Stringer interface {
+	String() string
+}
+func Println(a ...interface{}) {}
+`
+
+func TestKeepDynamic(t *testing.T) {
+	for _, test := range []struct {
+		src        string
+		interfaces []Interface
+		unused     []string
+		kept       map[string]string // qualifier to the prefix of the reason
+	}{
+		// values converted to the empty interface
+		{
+			src: `package main
+import "fmt"
+type T int
+func (T) String() string { return "" }
+func (T) Other() {}
+type U struct{}
+func (*U) MarshalJSON() ([]byte, error) { return nil, nil }
+type V int
+func (V) String() string { return "" }
+func main() {
+	fmt.Println(T(1))
+	var x interface{}
+	x = &U{}
+	_ = x
+	_ = V(1)
+}
+`,
+			unused: []string{`"main".T`, `"main".U`, `"main".V`, `("main".T).Other`, `("main".V).String`},
+			kept: map[string]string{
+				`("main".T).String`:      `implements fmt.Stringer, and main.T is converted to interface{} at /go/src/main/0.go:11:14`,
+				`("main".U).MarshalJSON`: `implements encoding/json.Marshaler, and *main.U is converted to interface{}`,
+			},
+		},
+		// the pointer methods are not called on values, the interfaces are configurable
+		{
+			src: `package main
+type T int
+func (*T) String() string { return "" }
+func (T) Plugin(n int) error { return nil }
+func f() interface{} {
+	return T(1)
+}
+`,
+			interfaces: []Interface{{Name: "main.Plugin", Methods: []string{"Plugin(int) error"}}},
+			unused:     []string{`"main".T`, `("main".T).String`},
+			kept: map[string]string{
+				`("main".T).Plugin`: `implements main.Plugin, and main.T is converted to interface{} at /go/src/main/0.go:6:9`,
+			},
+		},
+	} {
+		conf := &Config{
+			Context: fakeContext(map[string][]string{
+				"main": {test.src},
+				"fmt":  {fakeFmt},
+			}),
+			Interfaces: test.interfaces,
+		}
+		u, err := Load(conf, "main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkKept(t, u, test.unused, test.kept)
+	}
+}
+
+func TestKeepNonGo(t *testing.T) {
+	src := `package main
+//export Exported
+func Exported() {}
+//go:wasmexport add
+func Add(a, b int32) int32 { return a + b }
+//go:linkname Linked runtime.nanotime
+func Linked() int64
+func Unused() {}
+`
+	u, err := New(fakeContext(map[string][]string{"main": {src}}), "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKept(t, u, []string{`"main".Unused`}, map[string]string{
+		`"main".Exported`: "exported to C by //export at /go/src/main/0.go:2:1",
+		`"main".Add`:      "exported to WebAssembly by //go:wasmexport at /go/src/main/0.go:4:1",
+		`"main".Linked`:   "bound by //go:linkname at /go/src/main/0.go:6:1",
+	})
+}
+
+func TestKeepAssembly(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod": "module m\n\ngo 1.18\n",
+		"asm/asm.go": `package asm
+func Add(a, b int64) int64
+func Sub(a, b int64) int64 { return a - b }
+var Table [4]int64
+func Unused() {}
+`,
+		"asm/asm_amd64.s": `#include "textflag.h"
+
+// func Add(a, b int64) int64
+TEXT ·Add(SB), NOSPLIT, $0-24
+	MOVQ a+0(FP), AX
+	ADDQ b+8(FP), AX
+	MOVQ AX, ret+16(FP)
+	CALL m∕asm·Sub(SB)
+	MOVQ ·Table(SB), AX
+	JMP ·helper<>(SB)
+	RET
+`,
+	})
+	u, err := Load(&Config{Dir: dir, Env: append(os.Environ(), "GOWORK=off", "GOFLAGS=", "GOARCH=amd64")}, "./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm := filepath.Join(dir, "asm", "asm_amd64.s")
+	checkKept(t, u, []string{`"m/asm".Unused`}, map[string]string{
+		`"m/asm".Add`:   "referenced by the assembly at " + asm + ":4",
+		`"m/asm".Sub`:   "referenced by the assembly at " + asm + ":8",
+		`"m/asm".Table`: "referenced by the assembly at " + asm + ":9",
+	})
+}
+
+func TestKeepDirectives(t *testing.T) {
+	lib := `package lib
+//unexport:keep entry point of the customers
+func API() {}
+//unexport:keep
+type T struct {
+	A int //unexport:keep
+	B int
+}
+type I interface {
+	//unexport:keep called by the plugins
+	M()
+	N()
+}
+//unexport:keep
+const (
+	C = 1
+	D = 2
+)
+//unexport:keep
+func Used() {}
+//unexport:keep
+func unexported() {}
+//unexport:keep nothing
+`
+	main := `package main
+import "lib"
+func main() { lib.Used() }
+`
+	u, err := New(fakeContext(map[string][]string{"lib": {lib}, "main": {main}}), "lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKept(t, u, []string{`"lib".I`, `("lib".I).N`, `("lib".T).B`}, map[string]string{
+		`"lib".API`:   "marked by //unexport:keep at /go/src/lib/0.go:2:1: entry point of the customers",
+		`"lib".T`:     "marked by //unexport:keep at /go/src/lib/0.go:4:1",
+		`("lib".T).A`: "marked by //unexport:keep at /go/src/lib/0.go:6:8",
+		`("lib".I).M`: "marked by //unexport:keep at /go/src/lib/0.go:10:2: called by the plugins",
+		`"lib".C`:     "marked by //unexport:keep at /go/src/lib/0.go:14:1",
+		`"lib".D`:     "marked by //unexport:keep at /go/src/lib/0.go:14:1",
+	})
+	var stale []string
+	for _, d := range u.Stale {
+		stale = append(stale, d.String())
+	}
+	want := []string{
+		"/go/src/lib/0.go:19:1: Used is used by other packages",
+		"/go/src/lib/0.go:21:1: unexported is not exported",
+		"/go/src/lib/0.go:23:1: //unexport:keep is not attached to a declaration",
+	}
+	if !reflect.DeepEqual(stale, want) {
+		t.Errorf("expected the stale directives %v, got %v", want, stale)
+	}
+}
+
+func TestKeepRules(t *testing.T) {
+	src := `package main
+import (
+	"database/sql/driver"
+	"flag"
+	"net/rpc"
+)
+type Args struct{}
+type Service struct{}
+func (*Service) Multiply(args Args, reply *int) error { return nil }
+func (*Service) Helper(args Args) error { return nil }
+type Level int
+func (*Level) String() string { return "" }
+func (*Level) Set(string) error { return nil }
+type ID int
+func (*ID) Scan(src interface{}) error { return nil }
+func (ID) Value() (driver.Value, error) { return nil, nil }
+func (ID) Plugin() {}
+func main() {
+	rpc.Register(new(Service))
+	_ = flag.Var
+}
+`
+	plugins := KeepFunc(func(obj types.Object, pkgs []*types.Package) string {
+		if obj.Name() == "Plugin" {
+			return "called by the plugins"
+		}
+		return ""
+	})
+	conf := &Config{
+		Context: fakeContext(map[string][]string{
+			"main":                {src},
+			"net/rpc":             {"package rpc\nfunc Register(rcvr interface{}) error { return nil }\n"},
+			"flag":                {"package flag\ntype Value interface {\n\tString() string\n\tSet(string) error\n}\nfunc Var(value Value, name, usage string) {}\n"},
+			"database/sql/driver": {"package driver\ntype Value interface{}\n"},
+		}),
+		KeepRules: append(DefaultKeepRules, plugins),
+	}
+	u, err := Load(conf, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKept(t, u, []string{`"main".Args`, `"main".ID`, `"main".Level`, `"main".Service`, `("main".Service).Helper`}, map[string]string{
+		`("main".Service).Multiply`: "has the signature of a net/rpc method",
+		`("main".Level).String`:     "implements flag.Value",
+		`("main".Level).Set`:        "implements flag.Value",
+		`("main".ID).Scan`:          "implements database/sql.Scanner",
+		`("main".ID).Value`:         "implements database/sql/driver.Valuer",
+		`("main".ID).Plugin`:        "called by the plugins",
+	})
+}
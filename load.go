@@ -0,0 +1,252 @@
+package unexport
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"go/token"
+	"go/types"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/refactor/importgraph"
+)
+
+// TestPolicy tells how the uses of an identifier from test files are considered
+type TestPolicy int
+
+const (
+	// TestUsesKeep counts the uses from tests as real uses, the identifier stays exported
+	TestUsesKeep TestPolicy = iota
+	// TestUsesIgnore ignores the uses from tests, the identifier is reported as unused
+	TestUsesIgnore
+	// TestUsesReport reports the identifiers used only from tests, see ObjectInfo.TestOnly
+	TestUsesReport
+)
+
+// Config describes how the workspace is loaded through go/packages, it works
+// with Go modules and go.work workspaces, as well as GOPATH.
+type Config struct {
+	// Context selects the GOPATH loader instead of go/packages if not nil,
+	// the packages are found in the build context
+	Context *build.Context
+	// Dir is the directory in which the go command runs, the current directory if empty
+	Dir string
+	// Env is the environment of the go command, the current environment if nil
+	Env []string
+	// BuildFlags are the extra flags passed to the go command, e.g. -tags
+	BuildFlags []string
+	// Tests loads the test files, and the external test packages, of every affected package
+	Tests bool
+	// TestPolicy tells how the uses from test files are considered
+	TestPolicy TestPolicy
+	// GeneratedPolicy tells how the uses from generated files are considered,
+	// the generated files are never rewritten
+	GeneratedPolicy GeneratedPolicy
+	// AllowErrors tolerates the packages that fail to type-check, the analysis
+	// continues with partial type information, and the identifiers that may be
+	// used by them are marked, see ObjectInfo.Unproven
+	AllowErrors bool
+	// Matrix lists the build configurations (GOOS, GOARCH and build tags) to load,
+	// the results are merged so that a renaming is only offered if it's safe in
+	// all of them. Only the current configuration is loaded if empty.
+	Matrix []BuildConfig
+	// Interfaces are the interfaces whose methods are called dynamically, the
+	// methods implementing them stay exported if the type is converted to an
+	// interface type. DefaultInterfaces are used if nil.
+	Interfaces []Interface
+	// KeepRules decide whether the identifiers not used by the other packages
+	// must stay exported by convention. DefaultKeepRules are used if nil.
+	KeepRules []KeepRule
+	// Namer names the unexported identifiers, DefaultNamer is used if nil
+	Namer Namer
+	// Project is the policy of the project, e.g. read from the .unexport.json
+	// file, see FindProjectConfig
+	Project *ProjectConfig
+	// Journal is the file recording the renamings applied, to undo them, see
+	// Undo, nothing is recorded if empty
+	Journal string
+}
+
+func (conf *Config) packagesConfig(mode packages.LoadMode) *packages.Config {
+	return &packages.Config{
+		Mode:       mode,
+		Dir:        conf.Dir,
+		Env:        conf.Env,
+		BuildFlags: conf.BuildFlags,
+		Tests:      conf.Tests,
+	}
+}
+
+// Load creates a new Unexporter for the packages matching the patterns, which
+// are understood by the go command, e.g. import paths, directories relative to
+// conf.Dir or "./...". The matched packages are loaded as one program and
+// analyzed together. The packages that may use them are searched within the
+// main modules, i.e. the current module, or all the modules of the go.work workspace.
+func Load(conf *Config, patterns ...string) (*Unexporter, error) {
+	if len(conf.Matrix) > 0 {
+		return loadMatrix(conf, patterns)
+	}
+	if conf.Context != nil {
+		targets := expandPatterns(conf.Context, patterns)
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("no packages matching %s", strings.Join(patterns, " "))
+		}
+		prog, err := loadProgram(conf, scanWorkspace(conf.Context, targets...))
+		if err != nil {
+			return nil, err
+		}
+		return newUnexporter(conf, prog, targets), nil
+	}
+	targets, err := resolvePackages(conf, patterns)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := scanModules(conf, targets)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := loadPackages(conf, pkgs)
+	if err != nil {
+		return nil, err
+	}
+	return newUnexporter(conf, prog, targets), nil
+}
+
+// resolvePackages returns the import paths of the packages matching the patterns
+func resolvePackages(conf *Config, patterns []string) ([]string, error) {
+	cfg := conf.packagesConfig(packages.NeedName)
+	cfg.Tests = false
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, pkg := range pkgs {
+		if isTestMain(pkg) {
+			continue
+		}
+		if len(pkg.Errors) > 0 {
+			return nil, pkg.Errors[0]
+		}
+		paths = append(paths, pkg.PkgPath)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no packages matching %s", strings.Join(patterns, " "))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// mainModules returns the paths of the main modules, that's the current module
+// or every module of the go.work workspace
+func mainModules(conf *Config) ([]string, error) {
+	cmd := exec.Command("go", append([]string{"list", "-m", "-f", "{{.Path}}"}, conf.BuildFlags...)...)
+	cmd.Dir = conf.Dir
+	cmd.Env = conf.Env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -m: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// scanModules is the go/packages counterpart of scanWorkspace, it builds the
+// reverse import graph of the main modules and returns the packages affected
+// by the renaming of the identifiers in paths.
+func scanModules(conf *Config, paths []string) ([]string, error) {
+	mods, err := mainModules(conf)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, mod := range mods {
+		patterns = append(patterns, mod+"/...")
+	}
+	pkgs, err := packages.Load(conf.packagesConfig(packages.NeedName|packages.NeedImports|packages.NeedForTest), patterns...)
+	if err != nil {
+		return nil, err
+	}
+	rev := make(importgraph.Graph)
+	for _, pkg := range pkgs {
+		if isTestMain(pkg) {
+			continue
+		}
+		// the tests are loaded along with the package they test
+		path := pkg.PkgPath
+		if pkg.ForTest != "" {
+			path = pkg.ForTest
+		}
+		for imp := range pkg.Imports {
+			if rev[imp] == nil {
+				rev[imp] = make(map[string]bool)
+			}
+			rev[imp][path] = true
+		}
+	}
+	var affectedPackages []string
+	for pkg := range rev.Search(paths...) {
+		affectedPackages = append(affectedPackages, pkg)
+	}
+	return affectedPackages, nil
+}
+
+// isTestMain reports whether pkg is the generated main package of a test binary
+func isTestMain(pkg *packages.Package) bool {
+	return strings.HasSuffix(pkg.ID, ".test")
+}
+
+// loadPackages type-checks the packages from source, and presents the result
+// as a loader.Program, so that the renaming logic is shared with the GOPATH loader.
+// The packages are Imported, while their test variants and the external test
+// packages are Created.
+func loadPackages(conf *Config, paths []string) (*loader.Program, error) {
+	const mode = packages.NeedName | packages.NeedFiles | packages.NeedImports |
+		packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo
+	cfg := conf.packagesConfig(mode)
+	cfg.Fset = token.NewFileSet()
+	pkgs, err := packages.Load(cfg, paths...)
+	if err != nil {
+		return nil, err
+	}
+	prog := &loader.Program{
+		Fset:        cfg.Fset,
+		Imported:    make(map[string]*loader.PackageInfo),
+		AllPackages: make(map[*types.Package]*loader.PackageInfo),
+	}
+	for _, pkg := range pkgs {
+		if isTestMain(pkg) {
+			continue
+		}
+		if len(pkg.Errors) > 0 && !conf.AllowErrors {
+			return nil, fmt.Errorf("couldn't load package %s: %v", pkg.PkgPath, pkg.Errors[0])
+		}
+		if pkg.Types == nil || pkg.TypesInfo == nil {
+			continue
+		}
+		var errs []error
+		for _, err := range pkg.Errors {
+			errs = append(errs, err)
+		}
+		info := &loader.PackageInfo{
+			Pkg:                   pkg.Types,
+			Importable:            true,
+			TransitivelyErrorFree: len(errs) == 0,
+			Files:                 pkg.Syntax,
+			Errors:                errs,
+			Info:                  *pkg.TypesInfo,
+		}
+		if pkg.ID == pkg.PkgPath {
+			prog.Imported[pkg.PkgPath] = info
+		} else {
+			prog.Created = append(prog.Created, info)
+		}
+		prog.AllPackages[pkg.Types] = info
+	}
+	return prog, nil
+}
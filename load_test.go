@@ -0,0 +1,272 @@
+package unexport
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	for _, test := range []struct {
+		files    map[string]string
+		dir      string
+		patterns []string
+		want     []string
+	}{
+		// single module
+		{
+			files: map[string]string{
+				"go.mod": "module example.com/m\n\ngo 1.18\n",
+				"foo/foo.go": `package foo
+type S int
+type T int
+`,
+				"bar/bar.go": `package bar
+import "example.com/m/foo"
+func f(t *foo.T) {}
+`,
+			},
+			patterns: []string{"./foo"},
+			want:     []string{`"example.com/m/foo".S`},
+		},
+		// go.work workspace, the consumer lives in another module
+		{
+			files: map[string]string{
+				"go.work":  "go 1.18\n\nuse (\n\t./a\n\t./b\n)\n",
+				"a/go.mod": "module example.com/a\n\ngo 1.18\n",
+				"a/a.go": `package a
+type S struct {
+F int
+G int
+}
+`,
+				"b/go.mod": "module example.com/b\n\ngo 1.18\n",
+				"b/b.go": `package b
+import "example.com/a"
+var _ = a.S{F: 1}
+`,
+			},
+			dir:      "a",
+			patterns: []string{"example.com/a"},
+			want:     []string{`("example.com/a".S).G`},
+		},
+		// all the packages of the module in a single run
+		{
+			files: map[string]string{
+				"go.mod": "module example.com/m\n\ngo 1.18\n",
+				"foo/foo.go": `package foo
+type S int
+type T int
+`,
+				"bar/bar.go": `package bar
+import "example.com/m/foo"
+func F(t *foo.T) {}
+`,
+				"bar/baz/baz.go": `package baz
+import "example.com/m/bar"
+var V = bar.F
+`,
+			},
+			patterns: []string{"./..."},
+			want:     []string{`"example.com/m/bar/baz".V`, `"example.com/m/foo".S`},
+		},
+	} {
+		dir := writeTree(t, test.files)
+		u, err := Load(&Config{Dir: filepath.Join(dir, test.dir), Env: append(os.Environ(), "GOWORK=", "GOFLAGS=")}, test.patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for obj := range u.Identifiers {
+			got = append(got, u.Qualifier(obj))
+		}
+		sort.Strings(got)
+		if len(got) != len(test.want) {
+			t.Errorf("expected %v, got %v", test.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		}
+	}
+}
+
+func TestLoadTests(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.18\n",
+		"foo/foo.go": `package foo
+type S int
+func F() {}
+func G() {}
+`,
+		"foo/foo_test.go": `package foo
+import "testing"
+func TestG(t *testing.T) { G() }
+`,
+		"foo/example_test.go": `package foo_test
+import "example.com/m/foo"
+func ExampleF() { foo.F() }
+`,
+	})
+	for _, test := range []struct {
+		tests    bool
+		policy   TestPolicy
+		want     []string
+		testOnly string
+		conflict string
+	}{
+		{want: []string{"F", "G", "S"}},
+		{tests: true, policy: TestUsesKeep, want: []string{"G", "S"}},
+		{tests: true, policy: TestUsesIgnore, want: []string{"F", "G", "S"}, conflict: "F"},
+		{tests: true, policy: TestUsesReport, want: []string{"F", "G", "S"}, testOnly: "F", conflict: "F"},
+	} {
+		conf := &Config{Dir: dir, Env: append(os.Environ(), "GOWORK=off", "GOFLAGS="), Tests: test.tests, TestPolicy: test.policy}
+		u, err := Load(conf, "./foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for obj, info := range u.Identifiers {
+			got = append(got, obj.Name())
+			if info.TestOnly != (obj.Name() == test.testOnly) {
+				t.Errorf("%s: expected TestOnly to be %v", obj.Name(), !info.TestOnly)
+			}
+			if (info.Warning != "") != (obj.Name() == test.conflict) {
+				t.Errorf("%s: unexpected warning %q", obj.Name(), info.Warning)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("expected %v, got %v", test.want, got)
+		}
+	}
+}
+
+func TestUpdateTests(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.18\n",
+		"foo/foo.go": `package foo
+
+func G() {}
+`,
+		"foo/foo_test.go": `package foo
+
+import "testing"
+
+func TestG(t *testing.T) { G() }
+`,
+	})
+	u, err := Load(&Config{Dir: dir, Env: append(os.Environ(), "GOWORK=off", "GOFLAGS="), Tests: true}, "./foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.UpdateAll(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "foo", "foo_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "{ g() }") {
+		t.Errorf("the use in test file is not renamed:\n%s", content)
+	}
+}
+
+func TestGenerated(t *testing.T) {
+	const generated = `// Code generated by stringer; DO NOT EDIT.
+
+package foo
+
+// the positions are those of the source of the generator
+//line t.y:1
+func (T) String() string { return "" }
+
+//line t.y:3
+func Gen() {}
+`
+	for _, test := range []struct {
+		policy    GeneratedPolicy
+		want      []string
+		conflicts []string
+	}{
+		{policy: GeneratedUsesKeep, want: []string{"F", "Gen", "String", "T"}, conflicts: []string{"Gen", "String", "T"}},
+		{policy: GeneratedUsesIgnore, want: []string{"F", "Gen", "String", "T", "Used"}, conflicts: []string{"Gen", "String", "T", "Used"}},
+	} {
+		dir := writeTree(t, map[string]string{
+			"go.mod": "module example.com/m\n\ngo 1.18\n",
+			"foo/foo.go": `package foo
+
+type T int
+
+func Used() {}
+
+func F() { Gen() }
+`,
+			"foo/t_string.go": generated,
+			"bar/bar.pb.go": `// Code generated by protoc-gen-go. DO NOT EDIT.
+
+package bar
+
+import "example.com/m/foo"
+
+//line bar.proto:1
+var _ = foo.Used
+`,
+		})
+		conf := &Config{Dir: dir, Env: append(os.Environ(), "GOWORK=off", "GOFLAGS="), GeneratedPolicy: test.policy}
+		u, err := Load(conf, "./...")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, conflicts []string
+		for obj, info := range u.Identifiers {
+			got = append(got, obj.Name())
+			if info.Warning != "" {
+				conflicts = append(conflicts, obj.Name())
+				if !strings.Contains(info.Warning, "would edit the generated file") {
+					t.Errorf("%s: unexpected warning %q", obj.Name(), info.Warning)
+				}
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(conflicts)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("expected %v, got %v", test.want, got)
+		}
+		if !reflect.DeepEqual(conflicts, test.conflicts) {
+			t.Errorf("expected the conflicts %v, got %v", test.conflicts, conflicts)
+		}
+		// the generated files are left unchanged, the identifiers they
+		// refer to are conflicts
+		if err := u.UpdateAll(); (err != nil) != (len(test.conflicts) > 0) || err != nil && !strings.Contains(err.Error(), "causes conflicts") {
+			t.Errorf("expected the conflicts %v, got %v", test.conflicts, err)
+		}
+		content, err := os.ReadFile(filepath.Join(dir, "foo", "t_string.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != generated {
+			t.Errorf("the generated file is rewritten:\n%s", content)
+		}
+	}
+}
+
+// writeTree writes the files, keyed by slash separated paths, to a temporary directory
+func writeTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
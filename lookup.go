@@ -0,0 +1,248 @@
+package unexport
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template/parse"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// lookups are the reflective lookups by name, by types.Func.FullName,
+// with the kind of object looked up
+var lookups = map[string]string{
+	"(reflect.Value).MethodByName": "method",
+	"(reflect.Value).FieldByName":  "field",
+	"(reflect.Type).MethodByName":  "method",
+	"(reflect.Type).FieldByName":   "field",
+}
+
+// templateParsers are the functions parsing a template, by types.Func.FullName,
+// with the kind of arguments, the text or the file names and patterns
+var templateParsers = map[string]string{}
+
+func init() {
+	for _, path := range []string{"text/template", "html/template"} {
+		templateParsers["(*"+path+".Template).Parse"] = "text"
+		templateParsers["(*"+path+".Template).ParseFiles"] = "files"
+		templateParsers["(*"+path+".Template).ParseGlob"] = "glob"
+		templateParsers[path+".ParseFiles"] = "files"
+		templateParsers[path+".ParseGlob"] = "glob"
+	}
+}
+
+// keepLookedUp keeps the fields and methods looked up by reflection with a
+// constant name, and the ones referenced by the templates, either constant
+// strings or files, the template files are relative to the directory of the
+// package. The type of the value is only known for the lookups of the form
+// reflect.ValueOf(v).MethodByName("M"), otherwise every field or method with
+// the name is kept.
+func (u *Unexporter) keepLookedUp(keep func(types.Object, string)) {
+	var byName map[string][]types.Object
+	keepNamed := func(kind, name, reason string) {
+		if byName == nil {
+			byName = u.fieldsAndMethods()
+		}
+		for _, obj := range byName[name] {
+			if kind == "" || objectKind(obj) == kind {
+				keep(obj, reason)
+			}
+		}
+	}
+	for _, info := range u.packages {
+		for _, f := range info.Files {
+			dir := filepath.Dir(u.iprog.Fset.File(f.Pos()).Name())
+			ast.Inspect(f, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				fn, ok := typeutil.Callee(&info.Info, call).(*types.Func)
+				if !ok {
+					return true
+				}
+				if kind, ok := lookups[fn.FullName()]; ok && len(call.Args) == 1 {
+					name, ok := constantString(info, call.Args[0])
+					if !ok {
+						return true
+					}
+					reason := fmt.Sprintf("looked up by %s(%q) at %s", fn.FullName(), name, u.iprog.Fset.Position(call.Pos()))
+					if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+						if t := reflectedType(info, sel.X); t != nil {
+							obj, _, _ := types.LookupFieldOrMethod(t, true, info.Pkg, name)
+							if obj != nil {
+								keep(obj, reason)
+							}
+							return true
+						}
+					}
+					keepNamed(kind, name, reason)
+					return true
+				}
+				switch templateParsers[fn.FullName()] {
+				case "text":
+					if len(call.Args) == 1 {
+						if text, ok := constantString(info, call.Args[0]); ok {
+							reason := fmt.Sprintf("referenced by the template at %s", u.iprog.Fset.Position(call.Args[0].Pos()))
+							templateFields(text, func(name string, _ int) {
+								keepNamed("", name, reason)
+							})
+						}
+					}
+				case "files", "glob":
+					for _, arg := range call.Args {
+						s, ok := constantString(info, arg)
+						if !ok {
+							continue
+						}
+						if !filepath.IsAbs(s) {
+							s = filepath.Join(dir, s)
+						}
+						filenames := []string{s}
+						if templateParsers[fn.FullName()] == "glob" {
+							filenames, _ = filepath.Glob(s)
+						}
+						for _, filename := range filenames {
+							text, err := ioutil.ReadFile(filename)
+							if err != nil {
+								continue
+							}
+							templateFields(string(text), func(name string, line int) {
+								keepNamed("", name, fmt.Sprintf("referenced by the template at %s:%d", filename, line))
+							})
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+}
+
+// fieldsAndMethods returns the exported fields and methods of the packages to unexport, by name
+func (u *Unexporter) fieldsAndMethods() map[string][]types.Object {
+	byName := make(map[string][]types.Object)
+	for path, info := range u.iprog.Imported {
+		if !u.paths[path] {
+			continue
+		}
+		for id, obj := range info.Defs {
+			if obj == nil || !id.IsExported() {
+				continue
+			}
+			if kind := objectKind(obj); kind == "field" || kind == "method" {
+				byName[obj.Name()] = append(byName[obj.Name()], obj)
+			}
+		}
+	}
+	return byName
+}
+
+// reflectedType returns the static type of the value reflected by x, if
+// known, e.g. T for reflect.ValueOf(&T{}).Elem()
+func reflectedType(info *loader.PackageInfo, x ast.Expr) types.Type {
+	call, ok := ast.Unparen(x).(*ast.CallExpr)
+	if !ok {
+		return nil
+	}
+	fn, ok := typeutil.Callee(&info.Info, call).(*types.Func)
+	if !ok {
+		return nil
+	}
+	switch fn.FullName() {
+	case "reflect.ValueOf", "reflect.TypeOf":
+		if t := info.TypeOf(call.Args[0]); t != nil && !types.IsInterface(t) {
+			return t
+		}
+	case "reflect.Indirect":
+		if t := reflectedType(info, call.Args[0]); t != nil {
+			return deref(t)
+		}
+	case "(reflect.Value).Elem", "(reflect.Type).Elem":
+		if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+			if t, ok := reflectedType(info, sel.X).(*types.Pointer); ok {
+				return t.Elem()
+			}
+		}
+	}
+	return nil
+}
+
+func constantString(info *loader.PackageInfo, x ast.Expr) (string, bool) {
+	if tv, ok := info.Types[x]; ok && tv.Value != nil && tv.Value.Kind() == constant.String {
+		return constant.StringVal(tv.Value), true
+	}
+	return "", false
+}
+
+// templateFields parses a template, and calls found with the names of the
+// fields and methods it refers to, and their line
+func templateFields(text string, found func(name string, line int)) {
+	t := parse.New("")
+	t.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := t.Parse(text, "", "", trees); err != nil {
+		return
+	}
+	for _, tree := range trees {
+		var walk func(n parse.Node)
+		idents := func(n parse.Node, names []string) {
+			line := 1 + strings.Count(text[:n.Position()], "\n")
+			for _, name := range names {
+				if ast.IsExported(name) {
+					found(name, line)
+				}
+			}
+		}
+		walk = func(n parse.Node) {
+			switch n := n.(type) {
+			case *parse.ListNode:
+				if n != nil {
+					for _, n := range n.Nodes {
+						walk(n)
+					}
+				}
+			case *parse.ActionNode:
+				walk(n.Pipe)
+			case *parse.PipeNode:
+				if n != nil {
+					for _, cmd := range n.Cmds {
+						walk(cmd)
+					}
+				}
+			case *parse.CommandNode:
+				for _, arg := range n.Args {
+					walk(arg)
+				}
+			case *parse.FieldNode:
+				idents(n, n.Ident)
+			case *parse.ChainNode:
+				walk(n.Node)
+				idents(n, n.Field)
+			case *parse.VariableNode:
+				idents(n, n.Ident[1:])
+			case *parse.IfNode:
+				walk(n.Pipe)
+				walk(n.List)
+				walk(n.ElseList)
+			case *parse.RangeNode:
+				walk(n.Pipe)
+				walk(n.List)
+				walk(n.ElseList)
+			case *parse.WithNode:
+				walk(n.Pipe)
+				walk(n.List)
+				walk(n.ElseList)
+			case *parse.TemplateNode:
+				walk(n.Pipe)
+			}
+		}
+		walk(tree.Root)
+	}
+}
@@ -0,0 +1,390 @@
+// Package lsp implements a language server reporting the unnecessarily exported
+// identifiers of a workspace. It publishes a diagnostic per identifier, offers
+// their renaming as code action, and shows the number of the other packages
+// using each exported identifier as code lens. The workspace is analyzed when
+// the server is initialized and whenever a file is saved.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/isaiah/unexport"
+)
+
+// server is the state of a language server, see Serve
+type server struct {
+	conf     unexport.Config
+	patterns []string
+	in       *bufio.Reader
+	out      io.Writer
+
+	report    *unexport.Report
+	usages    []unexport.Usage
+	published map[string]bool   // URIs with diagnostics
+	contents  map[string][]byte // file contents, to convert the positions
+	shutdown  bool
+}
+
+// Serve runs a language server over in and out until the client exits, the
+// packages matching the patterns, relative to the root of the workspace,
+// are loaded with conf, and the project configuration of the workspace, see
+// unexport.FindProjectConfig, unless conf.Project is set.
+func Serve(conf *unexport.Config, in io.Reader, out io.Writer, patterns ...string) error {
+	s := &server{
+		conf:      *conf,
+		patterns:  patterns,
+		in:        bufio.NewReader(in),
+		out:       out,
+		published: make(map[string]bool),
+	}
+	for {
+		msg, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit before shutdown")
+			}
+			return nil
+		}
+		result, rerr := s.handle(msg)
+		if msg.ID == nil {
+			// notification
+			continue
+		}
+		if err := s.reply(msg.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) handle(msg *message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		var params initializeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		root := params.RootURI
+		if len(params.WorkspaceFolders) > 0 {
+			root = params.WorkspaceFolders[0].URI
+		}
+		if root != "" {
+			s.conf.Dir = uriToPath(root)
+		}
+		return initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:   textDocumentSyncOptions{OpenClose: true, Save: saveOptions{}},
+				CodeActionProvider: codeActionOptions{CodeActionKinds: []string{"quickfix"}},
+				CodeLensProvider:   codeLensOptions{},
+				ExecuteCommandProvider: executeCommandOptions{
+					Commands: []string{showUsagesCommand},
+				},
+			},
+			ServerInfo: serverInfo{Name: "unexport"},
+		}, nil
+	case "initialized", "textDocument/didSave":
+		s.load()
+		return nil, nil
+	case "textDocument/codeAction":
+		var params codeActionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return s.codeActions(params), nil
+	case "textDocument/codeLens":
+		var params codeLensParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return s.codeLenses(params), nil
+	case "workspace/executeCommand":
+		var params executeCommandParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return s.executeCommand(params)
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	}
+	if msg.ID != nil {
+		return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+	// other notifications, e.g. textDocument/didOpen
+	return nil, nil
+}
+
+// load analyzes the workspace, and publishes the diagnostics
+func (s *server) load() {
+	conf := s.conf
+	var u *unexport.Unexporter
+	var err error
+	if conf.Project == nil {
+		// the project configuration is read again, it may have changed
+		conf.Project, err = unexport.FindProjectConfig(conf.Dir)
+	}
+	if err == nil {
+		u, err = unexport.Load(&conf, s.patterns...)
+	}
+	s.contents = make(map[string][]byte)
+	if err != nil {
+		s.report, s.usages = nil, nil
+		s.notify("window/showMessage", map[string]interface{}{"type": 1, "message": "unexport: " + err.Error()})
+	} else {
+		s.report, s.usages = u.Report(), u.Usages()
+	}
+	diagnostics := s.diagnostics()
+	for uri := range s.published {
+		if diagnostics[uri] == nil {
+			// clear the fixed identifiers
+			diagnostics[uri] = []diagnostic{}
+		}
+	}
+	s.published = make(map[string]bool)
+	for uri, ds := range diagnostics {
+		if len(ds) > 0 {
+			s.published[uri] = true
+		}
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: ds})
+	}
+}
+
+// diagnostics returns the diagnostics of the identifiers by URI
+func (s *server) diagnostics() map[string][]diagnostic {
+	diagnostics := make(map[string][]diagnostic)
+	if s.report == nil {
+		return diagnostics
+	}
+	for _, o := range s.report.Objects {
+		uri := pathToURI(o.Pos.Filename)
+		diagnostics[uri] = append(diagnostics[uri], s.diagnostic(o))
+	}
+	for _, ds := range diagnostics {
+		sort.Slice(ds, func(i, j int) bool {
+			a, b := ds[i].Range.Start, ds[j].Range.Start
+			return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+		})
+	}
+	return diagnostics
+}
+
+func (s *server) diagnostic(o unexport.ObjectReport) diagnostic {
+	d := diagnostic{
+		Range:    s.lspRange(o.Pos, len(o.Name)),
+		Severity: severityInformation,
+		Source:   "unexport",
+		Message:  fmt.Sprintf("%s %s is exported but not used outside of its package", o.Kind, o.Name),
+	}
+	if o.TestOnly {
+		d.Message += ", except by tests"
+	}
+	if len(o.Unproven) > 0 {
+		d.Message += fmt.Sprintf(", unproven as %s failed to type-check", strings.Join(o.Unproven, ", "))
+	}
+	if len(o.Conflicts) > 0 {
+		d.Severity = severityWarning
+		d.Message += fmt.Sprintf(", renaming it to %s causes conflicts", o.ProposedName)
+		for _, c := range o.Conflicts {
+			for _, c := range append([]unexport.ConflictReport{c}, c.Related...) {
+				d.RelatedInformation = append(d.RelatedInformation, diagnosticRelatedInformation{
+					Location: location{URI: pathToURI(c.Pos.Filename), Range: s.lspRange(c.Pos, 0)},
+					Message:  c.Message,
+				})
+			}
+		}
+	}
+	return d
+}
+
+// codeActions returns the renaming of the identifiers declared in the range
+func (s *server) codeActions(params codeActionParams) []codeAction {
+	actions := []codeAction{}
+	if s.report == nil {
+		return actions
+	}
+	filename := uriToPath(params.TextDocument.URI)
+	for _, o := range s.report.Objects {
+		if o.Pos.Filename != filename || len(o.Conflicts) > 0 {
+			continue
+		}
+		d := s.diagnostic(o)
+		if d.Range.End.Line < params.Range.Start.Line || d.Range.Start.Line > params.Range.End.Line {
+			continue
+		}
+		edit := &workspaceEdit{Changes: make(map[string][]textEdit)}
+		for _, e := range o.Edits {
+			for _, pos := range e.Occurrences {
+				uri := pathToURI(pos.Filename)
+				edit.Changes[uri] = append(edit.Changes[uri], textEdit{
+					Range:   s.lspRange(pos, len(e.From)),
+					NewText: e.To,
+				})
+			}
+		}
+		actions = append(actions, codeAction{
+			Title:       fmt.Sprintf("Unexport %s as %s", o.Name, o.ProposedName),
+			Kind:        "quickfix",
+			Diagnostics: []diagnostic{d},
+			Edit:        edit,
+		})
+	}
+	return actions
+}
+
+// codeLenses returns the number of the other packages using each exported identifier of the file
+func (s *server) codeLenses(params codeLensParams) []codeLens {
+	lenses := []codeLens{}
+	filename := uriToPath(params.TextDocument.URI)
+	for _, usage := range s.usages {
+		if usage.Pos.Filename != filename {
+			continue
+		}
+		title := "not used by other packages"
+		switch n := len(usage.Packages); n {
+		case 0:
+		case 1:
+			title = "used by 1 other package"
+		default:
+			title = fmt.Sprintf("used by %d other packages", n)
+		}
+		args := []interface{}{usage.Qualifier}
+		for _, path := range usage.Packages {
+			args = append(args, path)
+		}
+		lenses = append(lenses, codeLens{
+			Range:   s.lspRange(usage.Pos, 0),
+			Command: &command{Title: title, Command: showUsagesCommand, Arguments: args},
+		})
+	}
+	return lenses
+}
+
+// showUsagesCommand shows the other packages using an identifier, the
+// arguments are the qualifier of the identifier and the import paths
+const showUsagesCommand = "unexport.showUsages"
+
+// executeCommand runs the command of a code lens
+func (s *server) executeCommand(params executeCommandParams) (interface{}, *responseError) {
+	if params.Command != showUsagesCommand || len(params.Arguments) == 0 {
+		return nil, &responseError{Code: codeInvalidParams, Message: "unknown command: " + params.Command}
+	}
+	var paths []string
+	for _, arg := range params.Arguments[1:] {
+		paths = append(paths, fmt.Sprint(arg))
+	}
+	message := fmt.Sprintf("%v is not used by other packages", params.Arguments[0])
+	if len(paths) > 0 {
+		message = fmt.Sprintf("%v is used by %s", params.Arguments[0], strings.Join(paths, ", "))
+	}
+	s.notify("window/showMessage", map[string]interface{}{"type": 3, "message": message})
+	return nil, nil
+}
+
+// lspRange returns the range of n bytes at pos, the LSP characters are UTF-16 code units
+func (s *server) lspRange(pos unexport.Position, n int) lspRange {
+	start := s.lspPosition(pos, 0)
+	return lspRange{Start: start, End: s.lspPosition(pos, n)}
+}
+
+func (s *server) lspPosition(pos unexport.Position, n int) position {
+	p := position{Line: pos.Line - 1, Character: pos.Column - 1 + n}
+	content, ok := s.contents[pos.Filename]
+	if !ok {
+		content, _ = ioutil.ReadFile(pos.Filename)
+		s.contents[pos.Filename] = content
+	}
+	lineStart := pos.Offset - (pos.Column - 1)
+	if lineStart < 0 || pos.Offset+n > len(content) {
+		return p
+	}
+	p.Character = 0
+	for b := content[lineStart : pos.Offset+n]; len(b) > 0; {
+		r, size := utf8.DecodeRune(b)
+		p.Character += len(utf16.Encode([]rune{r}))
+		b = b[size:]
+	}
+	return p
+}
+
+func (s *server) reply(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	if rerr != nil {
+		return s.write(map[string]interface{}{"jsonrpc": "2.0", "id": id, "error": rerr})
+	}
+	return s.write(map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": result})
+}
+
+func (s *server) notify(method string, params interface{}) error {
+	return s.write(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (s *server) write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	return err
+}
+
+// readMessage reads a message, preceded by its headers
+func readMessage(r *bufio.Reader) (*message, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
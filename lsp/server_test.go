@@ -0,0 +1,181 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/isaiah/unexport"
+)
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.18\n",
+		"lib/lib.go": `package lib
+
+type T struct {
+	F int
+}
+
+func Unused() {}
+
+func f() { Unused() }
+`,
+		"app/main.go": `package main
+
+import "example.com/m/lib"
+
+func main() { _ = lib.T{} }
+`,
+	} {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lib := pathToURI(filepath.Join(dir, "lib", "lib.go"))
+
+	in, client := io.Pipe()
+	server, out := io.Pipe()
+	done := make(chan error)
+	go func() {
+		conf := &unexport.Config{Env: append(os.Environ(), "GOWORK=off", "GOFLAGS=")}
+		done <- Serve(conf, in, out, "./...")
+		out.Close()
+	}()
+	r := bufio.NewReader(server)
+	send := func(id int, method string, params interface{}) {
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+		if id > 0 {
+			msg["id"] = id
+		}
+		b, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(client, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	}
+	// receive returns the result of the request, or the params of the notification
+	receive := func(id int, method string, v interface{}) {
+		for {
+			var msg struct {
+				ID     int             `json:"id"`
+				Method string          `json:"method"`
+				Params json.RawMessage `json:"params"`
+				Result json.RawMessage `json:"result"`
+				Error  *responseError  `json:"error"`
+			}
+			m, err := readRaw(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(m, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Error != nil {
+				t.Fatalf("%s: %s", method, msg.Error.Message)
+			}
+			if id > 0 && msg.ID == id {
+				json.Unmarshal(msg.Result, v)
+				return
+			}
+			if id == 0 && msg.Method == method {
+				var uri struct{ URI string }
+				json.Unmarshal(msg.Params, &uri)
+				if uri.URI == lib {
+					json.Unmarshal(msg.Params, v)
+					return
+				}
+			}
+		}
+	}
+
+	send(1, "initialize", map[string]interface{}{"rootUri": pathToURI(dir)})
+	var init initializeResult
+	receive(1, "initialize", &init)
+	send(0, "initialized", struct{}{})
+	var published publishDiagnosticsParams
+	receive(0, "textDocument/publishDiagnostics", &published)
+	var messages []string
+	for _, d := range published.Diagnostics {
+		messages = append(messages, fmt.Sprintf("%d:%d %s", d.Range.Start.Line, d.Range.Start.Character, d.Message))
+	}
+	if want := []string{
+		"3:1 field F is exported but not used outside of its package",
+		"6:5 func Unused is exported but not used outside of its package",
+	}; !reflect.DeepEqual(messages, want) {
+		t.Errorf("expected diagnostics %v, got %v", want, messages)
+	}
+
+	send(2, "textDocument/codeAction", codeActionParams{
+		TextDocument: textDocumentIdentifier{URI: lib},
+		Range:        lspRange{Start: position{Line: 6}, End: position{Line: 6, Character: 5}},
+	})
+	var actions []codeAction
+	receive(2, "textDocument/codeAction", &actions)
+	if len(actions) != 1 || actions[0].Title != "Unexport Unused as unused" {
+		t.Fatalf("expected the renaming of Unused, got %+v", actions)
+	}
+	want := map[string][]textEdit{lib: {
+		{Range: lspRange{Start: position{Line: 6, Character: 5}, End: position{Line: 6, Character: 11}}, NewText: "unused"},
+		{Range: lspRange{Start: position{Line: 8, Character: 11}, End: position{Line: 8, Character: 17}}, NewText: "unused"},
+	}}
+	if !reflect.DeepEqual(actions[0].Edit.Changes, want) {
+		t.Errorf("expected the edits %v, got %v", want, actions[0].Edit.Changes)
+	}
+
+	send(3, "textDocument/codeLens", codeLensParams{TextDocument: textDocumentIdentifier{URI: lib}})
+	var lenses []codeLens
+	receive(3, "textDocument/codeLens", &lenses)
+	var titles []string
+	for _, l := range lenses {
+		titles = append(titles, fmt.Sprintf("%d %s", l.Range.Start.Line, l.Command.Title))
+	}
+	if want := []string{"2 used by 1 other package", "3 not used by other packages", "6 not used by other packages"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("expected the lenses %v, got %v", want, titles)
+	}
+
+	if !reflect.DeepEqual(init.Capabilities.ExecuteCommandProvider.Commands, []string{showUsagesCommand}) {
+		t.Errorf("expected the command %s, got %+v", showUsagesCommand, init.Capabilities.ExecuteCommandProvider)
+	}
+	for _, l := range lenses {
+		if l.Command.Command != showUsagesCommand {
+			t.Errorf("expected the command %s, got %+v", showUsagesCommand, l.Command)
+		}
+	}
+	send(4, "workspace/executeCommand", executeCommandParams{Command: lenses[0].Command.Command, Arguments: lenses[0].Command.Arguments})
+	receive(4, "workspace/executeCommand", nil)
+
+	send(5, "shutdown", nil)
+	receive(5, "shutdown", nil)
+	send(0, "exit", nil)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readRaw(r *bufio.Reader) ([]byte, error) {
+	var length int
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == "\r\n" {
+			break
+		}
+		fmt.Sscanf(line, "Content-Length: %d", &length)
+	}
+	b := make([]byte, length)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
@@ -0,0 +1,295 @@
+package unexport
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BuildConfig is one configuration of the build matrix, the empty fields
+// keep the values of the environment
+type BuildConfig struct {
+	GOOS   string
+	GOARCH string
+	Tags   []string
+}
+
+// ParseBuildConfig parses a build configuration of the form GOOS/GOARCH:tag1,tag2,
+// both parts are optional, e.g. "windows/amd64", "linux/arm64:integration" or ":integration"
+func ParseBuildConfig(s string) (BuildConfig, error) {
+	var bc BuildConfig
+	platform, tags, _ := strings.Cut(s, ":")
+	if platform != "" {
+		var ok bool
+		if bc.GOOS, bc.GOARCH, ok = strings.Cut(platform, "/"); !ok || bc.GOOS == "" || bc.GOARCH == "" {
+			return bc, fmt.Errorf("invalid build configuration %q, expected GOOS/GOARCH:tags", s)
+		}
+	}
+	if tags != "" {
+		bc.Tags = strings.Split(tags, ",")
+	}
+	return bc, nil
+}
+
+func (bc BuildConfig) String() string {
+	s := bc.GOOS + "/" + bc.GOARCH
+	if bc.GOOS == "" {
+		s = "default"
+	}
+	if len(bc.Tags) > 0 {
+		s += ":" + strings.Join(bc.Tags, ",")
+	}
+	return s
+}
+
+// configure returns a copy of conf that loads the packages in the build configuration
+func (conf *Config) configure(bc BuildConfig) *Config {
+	c := *conf
+	c.Matrix = nil
+	if conf.Context != nil {
+		ctxt := *conf.Context
+		if bc.GOOS != "" {
+			ctxt.GOOS, ctxt.GOARCH = bc.GOOS, bc.GOARCH
+		}
+		ctxt.BuildTags = append(append([]string(nil), ctxt.BuildTags...), bc.Tags...)
+		c.Context = &ctxt
+		return &c
+	}
+	c.Env = append([]string(nil), conf.Env...)
+	if conf.Env == nil {
+		c.Env = os.Environ()
+	}
+	if bc.GOOS != "" {
+		c.Env = append(c.Env, "GOOS="+bc.GOOS, "GOARCH="+bc.GOARCH)
+	}
+	if len(bc.Tags) > 0 {
+		c.BuildFlags = append(append([]string(nil), conf.BuildFlags...), "-tags="+strings.Join(bc.Tags, ","))
+	}
+	return &c
+}
+
+// loadMatrix loads the packages in every configuration of conf.Matrix, and
+// merges the results, see mergeViews
+func loadMatrix(conf *Config, patterns []string) (*Unexporter, error) {
+	var views []*Unexporter
+	for _, bc := range conf.Matrix {
+		v, err := Load(conf.configure(bc), patterns...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", bc, err)
+		}
+		v.label = bc.String()
+		views = append(views, v)
+	}
+	u := mergeViews(views)
+	u.journal = conf.Journal
+	return u, nil
+}
+
+// mergeViews merges the results of several build configurations. An identifier
+// is unused if it's unused in every configuration that declares it, its conflicts
+// are the conflicts in any of them, and the renaming is applied to the files of
+// all the configurations.
+func mergeViews(views []*Unexporter) *Unexporter {
+	u := &Unexporter{
+		views:       views,
+		Identifiers: make(map[types.Object]*ObjectInfo),
+		owners:      make(map[types.Object]*Unexporter),
+		equivalents: make(map[types.Object][]types.Object),
+		Kept:        make(map[types.Object]string),
+	}
+	decls := make([]map[string]types.Object, len(views))
+	for i, v := range views {
+		decls[i] = v.exportedDecls()
+	}
+	seen := make(map[string]bool)
+	for _, v := range views {
+		// kept in any of the configurations
+		for obj, reason := range v.Kept {
+			if key := v.declKey(obj); !seen[key] {
+				seen[key] = true
+				u.Kept[obj] = reason
+				u.owners[obj] = v
+			}
+		}
+	}
+	for _, v := range views {
+		for _, obj := range v.UnusedObjectsSorted() {
+			key := v.declKey(obj)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			eqs := make([]types.Object, len(views))
+			unused := true
+			for j := range views {
+				o := decls[j][key]
+				if o == nil {
+					continue // excluded from the configuration
+				}
+				if views[j].Identifiers[o] == nil {
+					unused = false
+					break
+				}
+				eqs[j] = o
+			}
+			if !unused {
+				continue
+			}
+			info := &ObjectInfo{}
+			var warnings []string
+			for j, o := range eqs {
+				if o == nil {
+					continue
+				}
+				oi := views[j].Identifiers[o]
+				info.TestOnly = info.TestOnly || oi.TestOnly
+				for _, path := range oi.Unproven {
+					if !contains(info.Unproven, path) {
+						info.Unproven = append(info.Unproven, path)
+					}
+				}
+				if oi.Warning != "" && !contains(warnings, oi.Warning) {
+					warnings = append(warnings, oi.Warning)
+					info.Warning = joinWarning(info.Warning, views[j].label, oi.Warning)
+					info.Conflicts = append(info.Conflicts, oi.Conflicts...)
+				}
+			}
+			sort.Strings(info.Unproven)
+			u.Identifiers[obj] = info
+			u.owners[obj] = v
+			u.equivalents[obj] = eqs
+			u.unexportableObjects = append(u.unexportableObjects, obj)
+		}
+	}
+	u.Stale = mergeStale(views)
+	return u
+}
+
+// mergeStale returns the directives stale in every configuration including their file
+func mergeStale(views []*Unexporter) []StaleDirective {
+	count := make(map[token.Position]int)
+	for _, v := range views {
+		for _, d := range v.Stale {
+			count[d.Pos]++
+		}
+	}
+	var stale []StaleDirective
+	seen := make(map[token.Position]bool)
+	for _, v := range views {
+		for _, d := range v.Stale {
+			if seen[d.Pos] {
+				continue
+			}
+			seen[d.Pos] = true
+			n := 0
+			for _, w := range views {
+				if w.hasFile(d.Pos.Filename) {
+					n++
+				}
+			}
+			if count[d.Pos] == n {
+				stale = append(stale, d)
+			}
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		a, b := stale[i].Pos, stale[j].Pos
+		return a.Filename < b.Filename || a.Filename == b.Filename && a.Offset < b.Offset
+	})
+	return stale
+}
+
+// hasFile reports whether the file is loaded in the configuration
+func (u *Unexporter) hasFile(filename string) bool {
+	for _, info := range u.packages {
+		for _, f := range info.Files {
+			if u.iprog.Fset.File(f.Pos()).Name() == filename {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exportedDecls returns the exported identifiers declared by the packages to unexport, by declKey
+func (u *Unexporter) exportedDecls() map[string]types.Object {
+	decls := make(map[string]types.Object)
+	for path, info := range u.iprog.Imported {
+		if !u.paths[path] {
+			continue
+		}
+		for id, obj := range info.Defs {
+			if obj != nil && id.IsExported() && !u.isTestFile(id.Pos()) {
+				decls[u.declKey(obj)] = obj
+			}
+		}
+	}
+	return decls
+}
+
+// declKey identifies the declaration of obj across build configurations
+func (u *Unexporter) declKey(obj types.Object) string {
+	pos := u.iprog.Fset.PositionFor(obj.Pos(), false)
+	return fmt.Sprintf("%s:%d:%s", pos.Filename, pos.Offset, objectKind(obj))
+}
+
+// matrixRenames merges the renames of objs in every configuration
+func (u *Unexporter) matrixRenames(objs ...types.Object) map[string]map[int]string {
+	renames := make(map[string]map[int]string)
+	for _, obj := range objs {
+		for i, eq := range u.equivalents[obj] {
+			if eq == nil {
+				continue
+			}
+			v := u.views[i]
+			for filename, offsets := range v.renames(v.Identifiers[eq].objsToUpdate) {
+				if renames[filename] == nil {
+					renames[filename] = make(map[int]string)
+				}
+				for offset, to := range offsets {
+					renames[filename][offset] = to
+				}
+			}
+		}
+	}
+	return renames
+}
+
+// matrixCheck checks the renaming in every configuration that declares from
+func (u *Unexporter) matrixCheck(from types.Object, to string) string {
+	var warning string
+	var conflicts []Conflict
+	for i, eq := range u.equivalents[from] {
+		if eq == nil {
+			continue
+		}
+		if w := u.views[i].Check(eq, to); w != "" {
+			warning = joinWarning(warning, u.views[i].label, w)
+			conflicts = append(conflicts, u.views[i].Identifiers[eq].Conflicts...)
+		}
+	}
+	u.Identifiers[from].Warning = warning
+	u.Identifiers[from].Conflicts = conflicts
+	return warning
+}
+
+// joinWarning appends the warning of a build configuration
+func joinWarning(warnings, label, warning string) string {
+	warning = "[" + label + "] " + warning
+	if warnings == "" {
+		return warning
+	}
+	return warnings + "\n" + warning
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package unexport
+
+import (
+	"go/token"
+	"go/types"
+	"strings"
+	"unicode"
+)
+
+// Namer is the naming strategy of the unexported identifiers
+type Namer interface {
+	// Unexport returns the new name of obj, an unexported identifier
+	Unexport(obj types.Object) string
+}
+
+// NamerFunc is a Namer as a function
+type NamerFunc func(obj types.Object) string
+
+// Unexport calls f
+func (f NamerFunc) Unexport(obj types.Object) string {
+	return f(obj)
+}
+
+// DefaultNamer lowercases the leading initialism, see UnexportName, and
+// avoids the keywords, and the predeclared identifiers at the package level,
+// e.g. Type becomes typ and Error becomes errorType. It's used if
+// Config.Namer is nil.
+var DefaultNamer Namer = NamerFunc(unexportedName)
+
+// keywordNames are the usual names used instead of the keywords
+var keywordNames = map[string]string{
+	"func":      "fn",
+	"import":    "imp",
+	"interface": "iface",
+	"package":   "pkg",
+	"range":     "rng",
+	"type":      "typ",
+}
+
+func unexportedName(obj types.Object) string {
+	name := UnexportName(obj.Name())
+	if token.IsKeyword(name) {
+		if alt, ok := keywordNames[name]; ok {
+			return alt
+		}
+		return name + kindSuffix(obj)
+	}
+	// the fields and methods are selected, they don't shadow anything
+	if isPackageLevel(obj) && types.Universe.Lookup(name) != nil {
+		return name + kindSuffix(obj)
+	}
+	return name
+}
+
+// kindSuffix returns the kind of obj as the suffix of a name, e.g. Func
+func kindSuffix(obj types.Object) string {
+	kind := objectKind(obj)
+	return strings.ToUpper(kind[:1]) + kind[1:]
+}
+
+// UnexportName lowercases the leading initialism of name, the Go way, e.g.
+// URLParser becomes urlParser, HTTP2Server becomes http2Server, IDs becomes
+// ids and ID becomes id, otherwise only the first letter is lowercased.
+func UnexportName(name string) string {
+	runes := []rune(name)
+	// the leading upper case letters and digits
+	n := 0
+	for n < len(runes) && (unicode.IsUpper(runes[n]) || unicode.IsDigit(runes[n])) {
+		n++
+	}
+	if n > 1 && n < len(runes) && unicode.IsLower(runes[n]) {
+		plural := runes[n] == 's' && (n+1 == len(runes) || !unicode.IsLower(runes[n+1]))
+		if plural {
+			n++
+		} else if unicode.IsUpper(runes[n-1]) {
+			// the last upper case letter starts the next word, e.g. P of URLParser
+			n--
+		}
+	}
+	return strings.ToLower(string(runes[:n])) + string(runes[n:])
+}
+
+// isValidIdentifier reports whether name can be declared and referred to,
+// it's neither a keyword nor the blank identifier
+func isValidIdentifier(name string) bool {
+	return token.IsIdentifier(name) && name != "_"
+}
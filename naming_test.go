@@ -0,0 +1,78 @@
+package unexport
+
+import (
+	"go/types"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestUnexportName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Foo":         "foo",
+		"FooBar":      "fooBar",
+		"URLParser":   "urlParser",
+		"HTTPClient":  "httpClient",
+		"HTTP2Server": "http2Server",
+		"ID":          "id",
+		"IDs":         "ids",
+		"IDsByName":   "idsByName",
+		"URL_Parser":  "url_Parser",
+		"A":           "a",
+		"TSet":        "tSet",
+		"Éclair":      "éclair",
+	} {
+		if got := UnexportName(name); got != want {
+			t.Errorf("%s: expected %s, got %s", name, want, got)
+		}
+	}
+}
+
+func TestNamer(t *testing.T) {
+	src := `package main
+type Type int
+func (Type) Range() {}
+type Error struct {
+	Map int
+}
+func Len() int { return 0 }
+var URLParser int
+`
+	for _, test := range []struct {
+		namer Namer
+		want  []string
+	}{
+		{
+			want: []string{`"main".Error errorType`, `"main".Len lenFunc`, `"main".Type typ`, `"main".URLParser urlParser`, `("main".Error).Map mapField`, `("main".Type).Range rng`},
+		},
+		{
+			namer: NamerFunc(func(obj types.Object) string { return "x" + obj.Name() }),
+			want:  []string{`"main".Error xError`, `"main".Len xLen`, `"main".Type xType`, `"main".URLParser xURLParser`, `("main".Error).Map xMap`, `("main".Type).Range xRange`},
+		},
+	} {
+		u, err := Load(&Config{Context: fakeContext(map[string][]string{"main": {src}}), Namer: test.namer}, "main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, o := range u.Report().Objects {
+			got = append(got, o.Qualifier+" "+o.ProposedName)
+			if len(o.Conflicts) > 0 {
+				t.Errorf("%s: unexpected conflicts %v", o.Qualifier, o.Conflicts)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("expected %v, got %v", test.want, got)
+		}
+		// the keywords are rejected
+		for obj := range u.Identifiers {
+			if obj.Name() == "Type" {
+				if w := u.Check(obj, "type"); !strings.Contains(w, `"type" is not a valid identifier`) {
+					t.Errorf("expected an invalid identifier, got %q", w)
+				}
+			}
+		}
+	}
+}
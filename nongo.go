@@ -0,0 +1,126 @@
+package unexport
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// asmSymbol matches the references to the Go symbols in the assembly files,
+// e.g. ·Foo(SB) or example.com∕m∕foo·Foo(SB), the slashes of the package
+// path are written as division slashes
+var asmSymbol = regexp.MustCompile(`([\pL\pN_.∕-]*)·(\pL[\pL\pN_]*)(<>)?\(SB\)`)
+
+// keepNonGo keeps the identifiers referred to by name out of the Go code,
+// renaming them breaks the build or the link: the functions implemented or
+// called by the assembly files, exported to C by a cgo //export directive,
+// exported by //go:wasmexport, and the ones bound by //go:linkname.
+func (u *Unexporter) keepNonGo(keep func(types.Object, string)) {
+	// lookup returns the package level object name of the package path,
+	// the package of info if path is empty
+	lookup := func(pkg *types.Package, path, name string) types.Object {
+		if path != "" {
+			info, ok := u.iprog.Imported[path]
+			if !ok {
+				return nil
+			}
+			pkg = info.Pkg
+		}
+		return pkg.Scope().Lookup(name)
+	}
+	dirs := make(map[string]*types.Package)
+	for _, info := range u.packages {
+		for _, f := range info.Files {
+			filename := u.iprog.Fset.File(f.Pos()).Name()
+			if !strings.HasSuffix(info.Pkg.Path(), "_test") {
+				dirs[filepath.Dir(filename)] = info.Pkg
+			}
+			for _, decl := range f.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv != nil || fn.Doc == nil {
+					continue
+				}
+				for _, c := range fn.Doc.List {
+					var reason string
+					if strings.HasPrefix(c.Text, "//export ") {
+						reason = "exported to C by //export"
+					} else if strings.HasPrefix(c.Text, "//go:wasmexport ") {
+						reason = "exported to WebAssembly by //go:wasmexport"
+					} else {
+						continue
+					}
+					if obj := info.Defs[fn.Name]; obj != nil {
+						keep(obj, fmt.Sprintf("%s at %s", reason, u.iprog.Fset.Position(c.Pos())))
+					}
+				}
+			}
+			for _, cg := range f.Comments {
+				for _, c := range cg.List {
+					if !strings.HasPrefix(c.Text, "//go:linkname ") {
+						continue
+					}
+					reason := fmt.Sprintf("bound by //go:linkname at %s", u.iprog.Fset.Position(c.Pos()))
+					args := strings.Fields(c.Text)[1:]
+					if len(args) > 0 {
+						if obj := lookup(info.Pkg, "", args[0]); obj != nil {
+							keep(obj, reason)
+						}
+					}
+					if len(args) > 1 {
+						if i := strings.LastIndex(args[1], "."); i > 0 {
+							if obj := lookup(info.Pkg, args[1][:i], args[1][i+1:]); obj != nil {
+								keep(obj, reason)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	// the assembly files are not loaded, they are found in the directories of the packages
+	var sorted []string
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	for _, dir := range sorted {
+		filenames, _ := filepath.Glob(filepath.Join(dir, "*.s"))
+		for _, filename := range filenames {
+			asmSymbols(filename, func(path, name string, line int) {
+				path = strings.ReplaceAll(path, "∕", "/")
+				if obj := lookup(dirs[dir], path, name); obj != nil {
+					keep(obj, fmt.Sprintf("referenced by the assembly at %s:%d", filename, line))
+				}
+			})
+		}
+	}
+}
+
+// asmSymbols calls found with the Go symbols referred to by an assembly file,
+// the package path is empty for the package of the file, the static symbols,
+// e.g. ·foo<>(SB), are skipped
+func asmSymbols(filename string, found func(path, name string, line int)) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = text[:i]
+		}
+		for _, m := range asmSymbol.FindAllStringSubmatch(text, -1) {
+			if m[3] == "" {
+				found(m[1], m[2], line)
+			}
+		}
+	}
+}
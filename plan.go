@@ -0,0 +1,158 @@
+package unexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/types"
+	"io/ioutil"
+	"sort"
+)
+
+// Plan is a list of renamings, to review or edit before applying them, see
+// Unexporter.ApplyPlan. It's stored as a JSON file, either written by
+// NewPlan, with the renamings of every candidate, e.g.
+//
+//	{"renamings": [{"qualifier": "\"example.com/m/foo\".URL", "to": "url", ...}]}
+//
+// the entries may be deleted or their new names changed, or written by hand
+// as a mapping of qualifier to new name, e.g.
+//
+//	{"\"example.com/m/foo\".URL": "url", "(\"example.com/m/foo\".T).ID": "Key"}
+type Plan struct {
+	Renamings []PlanEntry `json:"renamings"`
+}
+
+// PlanEntry is the renaming of an identifier, only the qualifier and the new
+// name are read, the other fields describe the renaming as it was planned
+type PlanEntry struct {
+	Qualifier string `json:"qualifier"`
+	To        string `json:"to"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Edits are the renamings needed, the identifier itself included
+	Edits     []Edit           `json:"edits,omitempty"`
+	Conflicts []ConflictReport `json:"conflicts,omitempty"`
+}
+
+// NewPlan returns the plan of renaming every identifier of the report to
+// its proposed name, the ones causing conflicts included
+func NewPlan(report *Report) *Plan {
+	plan := &Plan{Renamings: []PlanEntry{}}
+	for _, o := range report.Objects {
+		plan.Renamings = append(plan.Renamings, PlanEntry{
+			Qualifier: o.Qualifier,
+			To:        o.ProposedName,
+			Kind:      o.Kind,
+			Name:      o.Name,
+			Edits:     o.Edits,
+			Conflicts: o.Conflicts,
+		})
+	}
+	return plan
+}
+
+// ReadPlan reads a plan file, or a mapping of qualifier to new name, whose
+// entries are sorted by qualifier
+func ReadPlan(filename string) (*Plan, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if _, ok := fields["renamings"]; ok {
+		var plan Plan
+		if err := json.Unmarshal(b, &plan); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		return &plan, nil
+	}
+	var mapping map[string]string
+	if err := json.Unmarshal(b, &mapping); err != nil {
+		return nil, fmt.Errorf("%s: expected a plan or a mapping of qualifier to new name: %v", filename, err)
+	}
+	plan := &Plan{Renamings: []PlanEntry{}}
+	for q, to := range mapping {
+		plan.Renamings = append(plan.Renamings, PlanEntry{Qualifier: q, To: to})
+	}
+	sort.Slice(plan.Renamings, func(i, j int) bool {
+		return plan.Renamings[i].Qualifier < plan.Renamings[j].Qualifier
+	})
+	return plan, nil
+}
+
+// Write writes the plan file
+func (p *Plan) Write(filename string) error {
+	b, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(b, '\n'), 0644)
+}
+
+// ApplyPlan checks the renamings of the plan again, one at a time, in order,
+// and applies the ones free of conflicts, as Update does. The identifiers are
+// found by qualifier among the declarations of the packages, or only among
+// the unused identifiers with a build matrix. It returns the number of
+// renamings applied, and the errors of the other ones.
+func (u *Unexporter) ApplyPlan(plan *Plan) (int, []error) {
+	objs := u.qualifiedObjects()
+	var applied int
+	var errs []error
+	for _, e := range plan.Renamings {
+		obj := objs[e.Qualifier]
+		if obj == nil {
+			errs = append(errs, fmt.Errorf("%s: no such identifier", e.Qualifier))
+			continue
+		}
+		if e.To == obj.Name() {
+			continue
+		}
+		if warning := u.Check(obj, e.To); warning != "" {
+			errs = append(errs, fmt.Errorf("renaming %s to %s causes conflicts:\n%s", e.Qualifier, e.To, warning))
+			continue
+		}
+		if err := u.Update(obj); err != nil {
+			errs = append(errs, fmt.Errorf("renaming %s to %s: %v", e.Qualifier, e.To, err))
+			continue
+		}
+		applied++
+	}
+	return applied, errs
+}
+
+// qualifiedObjects returns the objects that can be renamed by qualifier: the
+// package-level objects, fields and methods declared by the packages to
+// unexport, or the unused identifiers with a build matrix
+func (u *Unexporter) qualifiedObjects() map[string]types.Object {
+	objs := make(map[string]types.Object)
+	if len(u.views) > 0 {
+		for obj := range u.Identifiers {
+			objs[u.Qualifier(obj)] = obj
+		}
+		return objs
+	}
+	for path, info := range u.iprog.Imported {
+		if !u.paths[path] {
+			continue
+		}
+		for _, obj := range info.Defs {
+			if obj != nil && (isPackageLevel(obj) || isFieldOrMethod(obj)) {
+				objs[u.Qualifier(obj)] = obj
+			}
+		}
+	}
+	return objs
+}
+
+func isFieldOrMethod(obj types.Object) bool {
+	if v, ok := obj.(*types.Var); ok {
+		return v.IsField()
+	}
+	if f, ok := obj.(*types.Func); ok {
+		return recv(f) != nil
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package unexport
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	ctxt := fakeContext(map[string][]string{
+		"foo": {"package foo\n\nfunc F() {}\n\nfunc G() {}\n\nvar H = 1\n"},
+		"bar": {"package bar\n\nimport \"foo\"\n\nvar _ = foo.H\n"},
+	})
+	u, err := New(ctxt, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "plan.json")
+	if err := NewPlan(u.Report()).Write(filename); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := ReadPlan(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var planned []string
+	for _, e := range plan.Renamings {
+		planned = append(planned, e.Qualifier+" "+e.To)
+	}
+	if !reflect.DeepEqual(planned, []string{`"foo".F f`, `"foo".G g`}) {
+		t.Fatalf("unexpected plan %v", planned)
+	}
+	// edited by hand
+	plan.Renamings = plan.Renamings[:1]
+	plan.Renamings[0].To = "fn"
+	got := captureRewrites(t)
+	if applied, errs := u.ApplyPlan(plan); applied != 1 || len(errs) > 0 {
+		t.Fatalf("expected 1 renaming applied, got %d %v", applied, errs)
+	}
+	if want := "package foo\n\nfunc fn() {}\n\nfunc G() {}\n\nvar H = 1\n"; got["/go/src/foo/0.go"] != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got["/go/src/foo/0.go"])
+	}
+
+	// a mapping of qualifier to new name
+	filename = filepath.Join(t.TempDir(), "mapping.json")
+	mapping := `{"\"foo\".H": "Height", "\"foo\".G": "type", "\"foo\".X": "x"}`
+	if err := os.WriteFile(filename, []byte(mapping), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err = ReadPlan(filename); err != nil {
+		t.Fatal(err)
+	}
+	if u, err = New(ctxt, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	applied, errs := u.ApplyPlan(plan)
+	if applied != 1 || len(errs) != 2 {
+		t.Fatalf("expected 1 renaming applied and 2 errors, got %d %v", applied, errs)
+	}
+	if !strings.Contains(errs[0].Error(), `"type" is not a valid identifier`) || !strings.Contains(errs[1].Error(), "no such identifier") {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if want := "package bar\n\nimport \"foo\"\n\nvar _ = foo.Height\n"; got["/go/src/bar/0.go"] != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got["/go/src/bar/0.go"])
+	}
+}
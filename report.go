@@ -0,0 +1,309 @@
+package unexport
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// Report is the machine-readable description of the unnecessarily exported
+// identifiers, see Unexporter.Report
+type Report struct {
+	Objects []ObjectReport `json:"objects"`
+	// Kept are the identifiers not used by the other packages, but kept exported
+	Kept []KeptReport `json:"kept,omitempty"`
+	// Stale are the //unexport:keep directives no longer needed
+	Stale []StaleReport `json:"stale,omitempty"`
+	// Errors lists the errors of the packages that failed to type-check, by import path
+	Errors map[string][]string `json:"errors,omitempty"`
+}
+
+// ObjectReport describes an unnecessarily exported identifier
+type ObjectReport struct {
+	Qualifier    string   `json:"qualifier"`
+	Kind         string   `json:"kind"`
+	Name         string   `json:"name"`
+	Pos          Position `json:"pos"`
+	ProposedName string   `json:"proposed_name"`
+	// Edits are the renamings needed to unexport the identifier, itself included
+	Edits     []Edit           `json:"edits"`
+	Conflicts []ConflictReport `json:"conflicts,omitempty"`
+	TestOnly  bool             `json:"test_only,omitempty"`
+	Unproven  []string         `json:"unproven,omitempty"`
+}
+
+// KeptReport describes an identifier kept exported, see Unexporter.Kept
+type KeptReport struct {
+	Qualifier string   `json:"qualifier"`
+	Kind      string   `json:"kind"`
+	Pos       Position `json:"pos"`
+	Reason    string   `json:"reason"`
+}
+
+// StaleReport describes a stale //unexport:keep directive, see Unexporter.Stale
+type StaleReport struct {
+	Pos     Position `json:"pos"`
+	Message string   `json:"message"`
+}
+
+// Edit is the renaming of an object
+type Edit struct {
+	Qualifier string   `json:"qualifier"`
+	Kind      string   `json:"kind"`
+	Pos       Position `json:"pos"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	// Occurrences are the identifiers to rename, the declaration included
+	Occurrences []Position `json:"occurrences"`
+}
+
+// ConflictReport is a renaming conflict, with the declarations and
+// references involved
+type ConflictReport struct {
+	Pos     Position         `json:"pos"`
+	Message string           `json:"message"`
+	Related []ConflictReport `json:"related,omitempty"`
+}
+
+// Position is a source position, the offset and column are in bytes,
+// the line and column start at 1
+type Position struct {
+	Filename string `json:"filename"`
+	Offset   int    `json:"offset"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+}
+
+func newPosition(pos token.Position) Position {
+	return Position{Filename: pos.Filename, Offset: pos.Offset, Line: pos.Line, Column: pos.Column}
+}
+
+// Report describes the unnecessarily exported identifiers, the renamings
+// and the conflicts
+func (u *Unexporter) Report() *Report {
+	report := &Report{Objects: []ObjectReport{}}
+	for _, obj := range u.UnusedObjectsSorted() {
+		info := u.Identifiers[obj]
+		edits := u.edits(obj)
+		o := ObjectReport{
+			Qualifier: u.Qualifier(obj),
+			Kind:      objectKind(obj),
+			Name:      obj.Name(),
+			Pos:       u.objPosition(obj),
+			Edits:     edits,
+			Conflicts: conflictReports(info.Conflicts),
+			TestOnly:  info.TestOnly,
+			Unproven:  info.Unproven,
+		}
+		for _, e := range edits {
+			if e.Qualifier == o.Qualifier {
+				o.ProposedName = e.To
+			}
+		}
+		report.Objects = append(report.Objects, o)
+	}
+	sort.SliceStable(report.Objects, func(i, j int) bool {
+		return report.Objects[i].Qualifier < report.Objects[j].Qualifier
+	})
+	for obj, reason := range u.Kept {
+		report.Kept = append(report.Kept, KeptReport{
+			Qualifier: u.Qualifier(obj),
+			Kind:      objectKind(obj),
+			Pos:       u.objPosition(obj),
+			Reason:    reason,
+		})
+	}
+	sort.Slice(report.Kept, func(i, j int) bool {
+		return report.Kept[i].Qualifier < report.Kept[j].Qualifier
+	})
+	for _, d := range u.Stale {
+		report.Stale = append(report.Stale, StaleReport{Pos: newPosition(d.Pos), Message: d.Message})
+	}
+	for path, errs := range u.Errors() {
+		if report.Errors == nil {
+			report.Errors = make(map[string][]string)
+		}
+		for _, err := range errs {
+			report.Errors[path] = append(report.Errors[path], err.Error())
+		}
+	}
+	return report
+}
+
+// edits returns the renamings of obj, by qualifier
+func (u *Unexporter) edits(obj types.Object) []Edit {
+	var edits []Edit
+	if len(u.views) > 0 {
+		// the renamings are the same in every configuration, but not
+		// the occurrences
+		byQualifier := make(map[string]int)
+		for i, eq := range u.equivalents[obj] {
+			if eq == nil {
+				continue
+			}
+			for _, e := range u.views[i].edits(eq) {
+				j, ok := byQualifier[e.Qualifier]
+				if !ok {
+					byQualifier[e.Qualifier] = len(edits)
+					edits = append(edits, e)
+					continue
+				}
+				for _, pos := range e.Occurrences {
+					if !containsPosition(edits[j].Occurrences, pos) {
+						edits[j].Occurrences = append(edits[j].Occurrences, pos)
+					}
+				}
+				sortPositions(edits[j].Occurrences)
+			}
+		}
+	} else {
+		objsToUpdate := u.Identifiers[obj].objsToUpdate
+		occurrences := u.occurrences(objsToUpdate)
+		for o, to := range objsToUpdate {
+			edits = append(edits, Edit{
+				Qualifier:   u.Qualifier(o),
+				Kind:        objectKind(o),
+				Pos:         u.objPosition(o),
+				From:        o.Name(),
+				To:          to,
+				Occurrences: occurrences[o],
+			})
+		}
+	}
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].Qualifier < edits[j].Qualifier
+	})
+	return edits
+}
+
+// occurrences returns the positions of the identifiers of objs, see renames
+func (u *Unexporter) occurrences(objs map[types.Object]string) map[types.Object][]Position {
+	occurrences := make(map[types.Object][]Position)
+	seen := make(map[token.Pos]bool)
+	add := func(id *ast.Ident, obj types.Object) {
+		obj = u.canonical(obj)
+		if _, ok := objs[obj]; ok && !seen[id.Pos()] {
+			seen[id.Pos()] = true
+			occurrences[obj] = append(occurrences[obj], newPosition(u.iprog.Fset.PositionFor(id.Pos(), false)))
+		}
+	}
+	for _, info := range u.packages {
+		for id, obj := range info.Defs {
+			if obj != nil {
+				add(id, obj)
+			}
+		}
+		for id, obj := range info.Uses {
+			add(id, obj)
+		}
+	}
+	for _, positions := range occurrences {
+		sortPositions(positions)
+	}
+	return occurrences
+}
+
+func sortPositions(positions []Position) {
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Filename != positions[j].Filename {
+			return positions[i].Filename < positions[j].Filename
+		}
+		return positions[i].Offset < positions[j].Offset
+	})
+}
+
+func containsPosition(positions []Position, pos Position) bool {
+	for _, p := range positions {
+		if p == pos {
+			return true
+		}
+	}
+	return false
+}
+
+// objPosition returns the position of the declaration of obj
+func (u *Unexporter) objPosition(obj types.Object) Position {
+	if v := u.owners[obj]; v != nil {
+		return v.objPosition(obj)
+	}
+	return newPosition(u.iprog.Fset.Position(obj.Pos()))
+}
+
+// conflictReports groups the lines of the conflicts, see Conflict
+func conflictReports(conflicts []Conflict) []ConflictReport {
+	var reports []ConflictReport
+	for _, c := range conflicts {
+		r := ConflictReport{Pos: newPosition(c.Pos), Message: strings.TrimPrefix(c.Message, "\t")}
+		if strings.HasPrefix(c.Message, "\t") && len(reports) > 0 {
+			last := &reports[len(reports)-1]
+			last.Related = append(last.Related, r)
+		} else {
+			reports = append(reports, r)
+		}
+	}
+	return reports
+}
+
+// Usage lists the other packages using an exported identifier
+type Usage struct {
+	Qualifier string   `json:"qualifier"`
+	Pos       Position `json:"pos"`
+	Packages  []string `json:"packages"`
+}
+
+// Usages returns the exported identifiers of the packages to unexport, with
+// the other packages referring to them, sorted by position
+func (u *Unexporter) Usages() []Usage {
+	var usages []Usage
+	if len(u.views) > 0 {
+		// an identifier declared in several configurations is used by any of them
+		byDecl := make(map[Position]int)
+		for _, v := range u.views {
+			for _, usage := range v.Usages() {
+				i, ok := byDecl[usage.Pos]
+				if !ok {
+					byDecl[usage.Pos] = len(usages)
+					usages = append(usages, usage)
+					continue
+				}
+				for _, path := range usage.Packages {
+					if !contains(usages[i].Packages, path) {
+						usages[i].Packages = append(usages[i].Packages, path)
+					}
+				}
+				sort.Strings(usages[i].Packages)
+			}
+		}
+	} else {
+		users := make(map[types.Object]map[string]bool)
+		for _, info := range u.packages {
+			for _, obj := range info.Uses {
+				if obj.Pkg() == nil || obj.Pkg().Path() == info.Pkg.Path() {
+					continue
+				}
+				obj = u.canonical(obj)
+				if users[obj] == nil {
+					users[obj] = make(map[string]bool)
+				}
+				users[obj][info.Pkg.Path()] = true
+			}
+		}
+		for _, obj := range u.exportedDecls() {
+			usage := Usage{Qualifier: u.Qualifier(obj), Pos: u.objPosition(obj), Packages: []string{}}
+			for path := range users[obj] {
+				usage.Packages = append(usage.Packages, path)
+			}
+			sort.Strings(usage.Packages)
+			usages = append(usages, usage)
+		}
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Pos.Filename != usages[j].Pos.Filename {
+			return usages[i].Pos.Filename < usages[j].Pos.Filename
+		}
+		return usages[i].Pos.Offset < usages[j].Pos.Offset
+	})
+	return usages
+}
@@ -0,0 +1,139 @@
+package unexport
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	u, err := New(main(`package main
+type S struct {
+	F int
+}
+var s int
+`), "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := u.Report()
+	if len(report.Objects) != 2 {
+		t.Fatalf("expected 2 objects, got %v", report.Objects)
+	}
+
+	field := report.Objects[1]
+	want := ObjectReport{
+		Qualifier:    `("main".S).F`,
+		Kind:         "field",
+		Name:         "F",
+		Pos:          Position{Filename: "/go/src/main/0.go", Offset: 30, Line: 3, Column: 2},
+		ProposedName: "f",
+		Edits: []Edit{{
+			Qualifier: `("main".S).F`,
+			Kind:      "field",
+			Pos:       Position{Filename: "/go/src/main/0.go", Offset: 30, Line: 3, Column: 2},
+			From:      "F",
+			To:        "f",
+			Occurrences: []Position{
+				{Filename: "/go/src/main/0.go", Offset: 30, Line: 3, Column: 2},
+			},
+		}},
+	}
+	if !reflect.DeepEqual(field, want) {
+		t.Errorf("expected %+v, got %+v", want, field)
+	}
+
+	typ := report.Objects[0]
+	if typ.Qualifier != `"main".S` || typ.Kind != "type" || typ.ProposedName != "s" {
+		t.Errorf("unexpected report %+v", typ)
+	}
+	if len(typ.Conflicts) != 1 || len(typ.Conflicts[0].Related) != 1 {
+		t.Fatalf("expected a conflict with the var s, got %+v", typ.Conflicts)
+	}
+	if related := typ.Conflicts[0].Related[0]; related.Pos.Line != 5 || related.Message != "conflicts with var in same block" {
+		t.Errorf("unexpected related conflict %+v", related)
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, report) {
+		t.Errorf("JSON round trip changed the report:\n%s", b)
+	}
+}
+
+func TestSARIF(t *testing.T) {
+	u, err := New(main(`package main
+type T int
+var _ T
+`), "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := u.Report().WriteSARIF(&buf, "/go/src"); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Results) != 1 {
+		t.Fatalf("unexpected log %s", buf.Bytes())
+	}
+	result := log.Runs[0].Results[0]
+	if result.RuleID != "unexport/type" || log.Runs[0].Tool.Driver.Rules[result.RuleIndex].ID != result.RuleID {
+		t.Errorf("unexpected rule %q", result.RuleID)
+	}
+	want := []sarifFix{{
+		Description: sarifMessage{Text: "Rename T to t"},
+		ArtifactChanges: []sarifArtifactChange{{
+			ArtifactLocation: sarifArtifactLocation{URI: "main/0.go", URIBaseID: "SRCROOT"},
+			Replacements: []sarifReplacement{{
+				DeletedRegion:   sarifRegion{StartLine: 2, StartColumn: 6, EndLine: 2, EndColumn: 7, ByteOffset: 18, ByteLength: 1},
+				InsertedContent: sarifMessage{Text: "t"},
+			}, {
+				DeletedRegion:   sarifRegion{StartLine: 3, StartColumn: 7, EndLine: 3, EndColumn: 8, ByteOffset: 30, ByteLength: 1},
+				InsertedContent: sarifMessage{Text: "t"},
+			}},
+		}},
+	}}
+	if !reflect.DeepEqual(result.Fixes, want) {
+		t.Errorf("expected fixes %+v, got %+v", want, result.Fixes)
+	}
+}
+
+func TestSARIFColumns(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "m.go")
+	src := "package m\n\nvar _ = \"é\"; var Ĳ int\n"
+	if err := os.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offset := strings.Index(src, "Ĳ")
+	report := &Report{Objects: []ObjectReport{{
+		Qualifier: `"m".Ĳ`, Kind: "var", Name: "Ĳ", ProposedName: "ĳ",
+		Pos: Position{Filename: filename, Offset: offset, Line: 3, Column: offset - len("package m\n\n") + 1},
+	}}}
+	var buf bytes.Buffer
+	if err := report.WriteSARIF(&buf, ""); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	// var _ = "é"; var Ĳ: the code point column of Ĳ is 18, its byte column 19
+	want := sarifRegion{StartLine: 3, StartColumn: 18, EndLine: 3, EndColumn: 19, ByteOffset: offset, ByteLength: 2}
+	if run := log.Runs[0]; run.ColumnKind != "unicodeCodePoints" || run.Results[0].Locations[0].PhysicalLocation.Region != want {
+		t.Errorf("expected the region %+v in code points, got %s", want, buf.Bytes())
+	}
+}
@@ -0,0 +1,129 @@
+package unexport
+
+import (
+	"go/types"
+	"strings"
+)
+
+// KeepRule decides whether an identifier, not used by the other packages,
+// must stay exported by convention, e.g. the methods of a net/rpc service
+type KeepRule interface {
+	// Keep returns the reason why obj stays exported, or the empty string,
+	// pkgs are the loaded packages, e.g. to check the imports
+	Keep(obj types.Object, pkgs []*types.Package) string
+}
+
+// KeepFunc is a KeepRule as a function
+type KeepFunc func(obj types.Object, pkgs []*types.Package) string
+
+// Keep calls f
+func (f KeepFunc) Keep(obj types.Object, pkgs []*types.Package) string {
+	return f(obj, pkgs)
+}
+
+// DefaultKeepRules are the conventions of the standard library and the
+// common test frameworks, used if Config.KeepRules is nil
+var DefaultKeepRules = []KeepRule{
+	KeepFunc(keepRPCMethods),
+	KeepFunc(keepSQLMethods),
+	KeepFunc(keepFlagValues),
+	KeepFunc(keepSuiteMethods),
+}
+
+// keepRPCMethods keeps the methods of the form
+//
+//	func (t *T) MethodName(argType T1, replyType *T2) error
+//
+// served by net/rpc, if any loaded package imports it
+func keepRPCMethods(obj types.Object, pkgs []*types.Package) string {
+	fn, ok := obj.(*types.Func)
+	if !ok || recv(fn) == nil || !imports(pkgs, "net/rpc") {
+		return ""
+	}
+	sig := fn.Type().(*types.Signature)
+	if sig.Params().Len() != 2 || sig.Results().Len() != 1 || sig.Variadic() {
+		return ""
+	}
+	if _, ok := sig.Params().At(1).Type().(*types.Pointer); !ok {
+		return ""
+	}
+	if !isError(sig.Results().At(0).Type()) {
+		return ""
+	}
+	return "has the signature of a net/rpc method"
+}
+
+// keepSQLMethods keeps the methods of the database/sql.Scanner and
+// database/sql/driver.Valuer interfaces, called on the arguments of the queries
+func keepSQLMethods(obj types.Object, pkgs []*types.Package) string {
+	fn, ok := obj.(*types.Func)
+	if !ok || recv(fn) == nil {
+		return ""
+	}
+	switch methodSignature(fn) {
+	case "Scan(any) error":
+		return "implements database/sql.Scanner"
+	case "Value() (database/sql/driver.Value, error)":
+		return "implements database/sql/driver.Valuer"
+	}
+	return ""
+}
+
+// keepFlagValues keeps the methods of the flag.Value implementations, if
+// the type has both methods
+func keepFlagValues(obj types.Object, pkgs []*types.Package) string {
+	fn, ok := obj.(*types.Func)
+	if !ok || recv(fn) == nil || !imports(pkgs, "flag") {
+		return ""
+	}
+	value := Interface{Name: "flag.Value", Methods: []string{"String() string", "Set(string) error"}}
+	for _, m := range implements(types.NewMethodSet(types.NewPointer(deref(recv(fn).Type()))), value) {
+		if m == obj {
+			return "implements flag.Value"
+		}
+	}
+	return ""
+}
+
+// suites are the test frameworks calling the methods of a suite by name,
+// with the names of the fixture methods
+var suites = map[string][]string{
+	"github.com/stretchr/testify/suite": {"SetupSuite", "TearDownSuite", "SetupTest", "TearDownTest", "BeforeTest", "AfterTest", "SetupSubTest", "TearDownSubTest", "HandleStats"},
+	"gopkg.in/check.v1":                 {"SetUpSuite", "TearDownSuite", "SetUpTest", "TearDownTest"},
+}
+
+// keepSuiteMethods keeps the test and fixture methods of the test suites,
+// if the package of the method, or its tests, import the test framework
+func keepSuiteMethods(obj types.Object, pkgs []*types.Package) string {
+	fn, ok := obj.(*types.Func)
+	if !ok || recv(fn) == nil {
+		return ""
+	}
+	for path, fixtures := range suites {
+		if !strings.HasPrefix(fn.Name(), "Test") && !contains(fixtures, fn.Name()) {
+			continue
+		}
+		for _, pkg := range pkgs {
+			if strings.TrimSuffix(pkg.Path(), "_test") == fn.Pkg().Path() && imports([]*types.Package{pkg}, path) {
+				return "called by the test framework " + path
+			}
+		}
+	}
+	return ""
+}
+
+// imports reports whether any of the packages imports path
+func imports(pkgs []*types.Package, path string) bool {
+	for _, pkg := range pkgs {
+		for _, imp := range pkg.Imports() {
+			if imp.Path() == path {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
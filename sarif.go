@@ -0,0 +1,250 @@
+package unexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// The SARIF 2.1.0 log, only the properties used by the report are declared,
+// see https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	ColumnKind         string                           `json:"columnKind"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult                    `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	RuleIndex        int             `json:"ruleIndex"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	Fixes            []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifLocation struct {
+	ID               int                   `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+	ByteOffset  int `json:"byteOffset"`
+	ByteLength  int `json:"byteLength,omitempty"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage          `json:"description"`
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion  `json:"deletedRegion"`
+	InsertedContent sarifMessage `json:"insertedContent"`
+}
+
+// sarifKinds are the kinds of the exported objects, a rule per kind
+var sarifKinds = []string{"const", "func", "type", "var", "field", "method"}
+
+const srcRoot = "SRCROOT"
+
+// WriteSARIF writes the report as a SARIF 2.1.0 log. The files under root,
+// if not empty, are relative to the SRCROOT base, a result is a warning if
+// the renaming has conflicts, a note otherwise, with the renaming as fix.
+func (r *Report) WriteSARIF(w io.Writer, root string) error {
+	driver := sarifDriver{
+		Name:           "unexport",
+		InformationURI: "https://github.com/isaiah/unexport",
+	}
+	rules := make(map[string]int)
+	for i, kind := range sarifKinds {
+		rules[kind] = i
+		driver.Rules = append(driver.Rules, newSarifRule(kind))
+	}
+	// the columns of the report are in bytes, they are converted
+	run := sarifRun{Tool: sarifTool{Driver: driver}, ColumnKind: "unicodeCodePoints", Results: []sarifResult{}}
+	files := make(sarifFiles)
+	if root != "" {
+		run.OriginalURIBaseIDs = map[string]sarifArtifactLocation{
+			srcRoot: {URI: fileURI(root) + "/"},
+		}
+	}
+	for _, o := range r.Objects {
+		if _, ok := rules[o.Kind]; !ok {
+			rules[o.Kind] = len(run.Tool.Driver.Rules)
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, newSarifRule(o.Kind))
+		}
+		result := sarifResult{
+			RuleID:    sarifRuleID(o.Kind),
+			RuleIndex: rules[o.Kind],
+			Level:     "note",
+			Message:   sarifMessage{Text: fmt.Sprintf("%s %s is exported but not used outside of its package", o.Kind, o.Name)},
+			Locations: []sarifLocation{{PhysicalLocation: files.physical(root, o.Pos, o.Name)}},
+		}
+		if o.TestOnly {
+			result.Message.Text += ", except by tests"
+		}
+		if len(o.Unproven) > 0 {
+			result.Message.Text += fmt.Sprintf(", unproven as %s failed to type-check", strings.Join(o.Unproven, ", "))
+		}
+		if len(o.Conflicts) > 0 {
+			result.Level = "warning"
+			result.Message.Text += fmt.Sprintf(", renaming it to %s causes conflicts", o.ProposedName)
+			for _, c := range o.Conflicts {
+				for _, c := range append([]ConflictReport{c}, c.Related...) {
+					result.RelatedLocations = append(result.RelatedLocations, sarifLocation{
+						ID:               len(result.RelatedLocations) + 1,
+						PhysicalLocation: files.physical(root, c.Pos, ""),
+						Message:          &sarifMessage{Text: c.Message},
+					})
+				}
+			}
+		} else {
+			result.Fixes = []sarifFix{files.renaming(root, o)}
+		}
+		run.Results = append(run.Results, result)
+	}
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(log)
+}
+
+func sarifRuleID(kind string) string {
+	return "unexport/" + kind
+}
+
+func newSarifRule(kind string) sarifRule {
+	return sarifRule{
+		ID:               sarifRuleID(kind),
+		Name:             "UnusedExported" + strings.ToUpper(kind[:1]) + kind[1:],
+		ShortDescription: sarifMessage{Text: fmt.Sprintf("exported %s not used outside of its package", kind)},
+	}
+}
+
+// renaming returns the replacements of the identifiers, grouped by file
+func (files sarifFiles) renaming(root string, o ObjectReport) sarifFix {
+	fix := sarifFix{Description: sarifMessage{Text: fmt.Sprintf("Rename %s to %s", o.Name, o.ProposedName)}}
+	changes := make(map[string]int)
+	for _, e := range o.Edits {
+		for _, pos := range e.Occurrences {
+			i, ok := changes[pos.Filename]
+			if !ok {
+				i = len(fix.ArtifactChanges)
+				changes[pos.Filename] = i
+				fix.ArtifactChanges = append(fix.ArtifactChanges, sarifArtifactChange{
+					ArtifactLocation: sarifArtifact(root, pos.Filename),
+				})
+			}
+			fix.ArtifactChanges[i].Replacements = append(fix.ArtifactChanges[i].Replacements, sarifReplacement{
+				DeletedRegion:   files.physical(root, pos, e.From).Region,
+				InsertedContent: sarifMessage{Text: e.To},
+			})
+		}
+	}
+	return fix
+}
+
+// sarifFiles are the contents of the files, read to convert the columns
+type sarifFiles map[string][]byte
+
+// physical returns the location of the identifier name at pos, or of pos if
+// name is empty, the columns are in code points, the lengths in bytes
+func (files sarifFiles) physical(root string, pos Position, name string) sarifPhysicalLocation {
+	column := files.column(pos)
+	region := sarifRegion{StartLine: pos.Line, StartColumn: column, ByteOffset: pos.Offset}
+	if name != "" {
+		region.EndLine = pos.Line
+		region.EndColumn = column + utf8.RuneCountInString(name)
+		region.ByteLength = len(name)
+	}
+	return sarifPhysicalLocation{ArtifactLocation: sarifArtifact(root, pos.Filename), Region: region}
+}
+
+// column returns the column of pos in code points, or in bytes if the file
+// can't be read
+func (files sarifFiles) column(pos Position) int {
+	src, ok := files[pos.Filename]
+	if !ok {
+		src, _ = ioutil.ReadFile(pos.Filename)
+		files[pos.Filename] = src
+	}
+	start := pos.Offset - (pos.Column - 1)
+	if start < 0 || pos.Offset > len(src) {
+		return pos.Column
+	}
+	return utf8.RuneCount(src[start:pos.Offset]) + 1
+}
+
+func sarifArtifact(root, filename string) sarifArtifactLocation {
+	if root != "" {
+		if rel, err := filepath.Rel(root, filename); err == nil && !strings.HasPrefix(rel, "..") {
+			return sarifArtifactLocation{URI: filepath.ToSlash(rel), URIBaseID: srcRoot}
+		}
+	}
+	return sarifArtifactLocation{URI: fileURI(filename)}
+}
+
+func fileURI(filename string) string {
+	path := filepath.ToSlash(filename)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // Windows drive
+	}
+	return "file://" + path
+}
@@ -0,0 +1,65 @@
+package unexport
+
+import (
+	"go/types"
+	"strconv"
+)
+
+// Alternatives returns at most n alternative names for obj, best first, the
+// renaming to each of them is checked to be free of conflicts. The checks
+// don't change the renaming of obj, see Check to choose one of them.
+func (u *Unexporter) Alternatives(obj types.Object, n int) []string {
+	to := u.newName(obj)
+	if to == "" {
+		return nil
+	}
+	var names []string
+	for _, name := range alternativeNames(obj, to) {
+		if len(names) == n {
+			break
+		}
+		if u.Check(obj, name) == "" {
+			names = append(names, name)
+		}
+	}
+	// restore the renaming of obj
+	u.Check(obj, to)
+	return names
+}
+
+// newName returns the name obj is renamed to, see Check
+func (u *Unexporter) newName(obj types.Object) string {
+	for i, eq := range u.equivalents[obj] {
+		if eq != nil {
+			return u.views[i].newName(eq)
+		}
+	}
+	if info := u.Identifiers[obj]; info != nil {
+		return info.objsToUpdate[obj]
+	}
+	return ""
+}
+
+// alternativeNames returns the candidate names of obj, instead of to, best
+// first: the name with the kind as suffix, e.g. fooFunc, prefixed by its
+// package or its type, e.g. ioReader or tMethod, and numbered
+func alternativeNames(obj types.Object, to string) []string {
+	var names []string
+	add := func(name string) {
+		if name != to && isValidIdentifier(name) && !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	add(to + kindSuffix(obj))
+	if f, ok := obj.(*types.Func); ok && recv(f) != nil {
+		if t := typeName(recv(f).Type()); t != "" {
+			add(UnexportName(t) + obj.Name())
+		}
+	} else if isPackageLevel(obj) {
+		add(obj.Pkg().Name() + obj.Name())
+	}
+	for i := 2; i <= 3; i++ {
+		add(to + strconv.Itoa(i))
+	}
+	return names
+}
@@ -0,0 +1,46 @@
+package unexport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAlternatives(t *testing.T) {
+	src := `package main
+type T int
+func (T) Foo() {}
+func (T) foo() {}
+func Bar() {}
+var bar, barFunc int
+`
+	u, err := New(fakeContext(map[string][]string{"main": {src}}), "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for obj, info := range u.Identifiers {
+		var want []string
+		switch obj.Name() {
+		case "Foo":
+			want = []string{"fooMethod", "tFoo", "foo2"}
+		case "Bar":
+			// barFunc is taken
+			want = []string{"mainBar", "bar2", "bar3"}
+		default:
+			continue
+		}
+		warning := info.Warning
+		if warning == "" {
+			t.Errorf("%s: expected a conflict", obj.Name())
+		}
+		if got := u.Alternatives(obj, 3); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected the alternatives %v, got %v", obj.Name(), want, got)
+		}
+		// the renaming is restored
+		if info.Warning != warning || u.newName(obj) != UnexportName(obj.Name()) {
+			t.Errorf("%s: the renaming is changed to %s", obj.Name(), u.newName(obj))
+		}
+		if u.Check(obj, want[0]) != "" || u.newName(obj) != want[0] {
+			t.Errorf("%s: expected the renaming to %s", obj.Name(), want[0])
+		}
+	}
+}
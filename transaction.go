@@ -0,0 +1,224 @@
+package unexport
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// transaction is a staged renaming, see Unexporter.stage
+type transaction struct {
+	files   map[string][]byte         // new contents, by file name
+	edits   map[string]map[int]string // renamings applied to the original contents
+	pkgs    map[string]bool           // import paths of the updated packages
+	renamed map[*ast.Ident]string     // old names of the renamed identifiers
+	nidents int
+}
+
+// rollback restores the syntax trees
+func (t *transaction) rollback() {
+	for id, name := range t.renamed {
+		id.Name = name
+	}
+}
+
+// verify type-checks again the packages of the configuration affected by
+// the staged files, in memory, with their new contents, it returns the type
+// errors of the packages that type-checked before the renaming
+func (u *Unexporter) verify(staged map[string][]byte) error {
+	affected := u.affectedPackages(staged)
+	checked := make(map[*types.Package]*types.Package)
+	var errs []string
+	var check func(info *loader.PackageInfo) *types.Package
+	check = func(info *loader.PackageInfo) *types.Package {
+		if pkg, ok := checked[info.Pkg]; ok {
+			return pkg
+		}
+		checked[info.Pkg] = info.Pkg // in case of an import cycle
+		var files []*ast.File
+		for _, f := range info.Files {
+			filename := u.iprog.Fset.File(f.Pos()).Name()
+			content, ok := staged[filename]
+			if !ok {
+				files = append(files, f)
+				continue
+			}
+			f, err := parser.ParseFile(u.iprog.Fset, filename, content, parser.ParseComments)
+			if err != nil {
+				errs = append(errs, err.Error())
+				return info.Pkg
+			}
+			files = append(files, f)
+		}
+		// the imports are resolved as in the loaded program, the
+		// test variants included, the other packages are unchanged
+		imports := make(map[string]*types.Package)
+		for _, imp := range info.Pkg.Imports() {
+			if dep := u.packages[imp]; dep != nil && affected[imp] {
+				imports[imp.Path()] = check(dep)
+			} else {
+				imports[imp.Path()] = imp
+			}
+		}
+		broken := len(info.Errors) > 0
+		conf := types.Config{
+			Importer: importerFunc(func(path string) (*types.Package, error) {
+				if pkg, ok := imports[path]; ok {
+					return pkg, nil
+				}
+				return nil, fmt.Errorf("package %s is not loaded", path)
+			}),
+			FakeImportC: true,
+			Error: func(err error) {
+				if !broken {
+					errs = append(errs, err.Error())
+				}
+			},
+		}
+		pkg, _ := conf.Check(info.Pkg.Path(), u.iprog.Fset, files, nil)
+		checked[info.Pkg] = pkg
+		return pkg
+	}
+	var infos []*loader.PackageInfo
+	for pkg := range affected {
+		infos = append(infos, u.packages[pkg])
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Pkg.Path() < infos[j].Pkg.Path() })
+	for _, info := range infos {
+		check(info)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("the renamed packages fail to type-check, no file is written:\n\t%s", strings.Join(errs, "\n\t"))
+	}
+	return nil
+}
+
+// affectedPackages returns the packages with a staged file, and the ones
+// importing them, directly or not
+func (u *Unexporter) affectedPackages(staged map[string][]byte) map[*types.Package]bool {
+	affected := make(map[*types.Package]bool)
+	for pkg, info := range u.packages {
+		for _, f := range info.Files {
+			if _, ok := staged[u.iprog.Fset.File(f.Pos()).Name()]; ok {
+				affected[pkg] = true
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for pkg := range u.packages {
+			if affected[pkg] {
+				continue
+			}
+			for _, imp := range pkg.Imports() {
+				if affected[imp] {
+					affected[pkg] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return affected
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+// writeFiles replaces the files atomically, with their permissions: the new
+// contents are written to temporary files first, then renamed over the files,
+// the files already replaced are restored if any write or rename fails
+var writeFiles = func(files map[string][]byte) (err error) {
+	type file struct {
+		filename string
+		tmp      string
+		orig     []byte
+		mode     os.FileMode
+	}
+	var staged []*file
+	defer func() {
+		for _, f := range staged {
+			if f.tmp != "" {
+				os.Remove(f.tmp)
+			}
+		}
+	}()
+	var filenames []string
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		content := files[filename]
+		// replace the target of a symbolic link
+		if target, err := filepath.EvalSymlinks(filename); err == nil {
+			filename = target
+		}
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		orig, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		f := &file{filename: filename, orig: orig, mode: fi.Mode().Perm()}
+		staged = append(staged, f)
+		if f.tmp, err = writeTemp(filename, content, f.mode); err != nil {
+			return err
+		}
+	}
+	for i, f := range staged {
+		if Verbose {
+			log.Printf("\t%s\n", f.filename)
+		}
+		if err := os.Rename(f.tmp, f.filename); err != nil {
+			// roll back
+			for _, done := range staged[:i] {
+				if tmp, rerr := writeTemp(done.filename, done.orig, done.mode); rerr == nil {
+					rerr = os.Rename(tmp, done.filename)
+					if rerr != nil {
+						os.Remove(tmp)
+						log.Printf("failed to restore %s: %v\n", done.filename, rerr)
+					}
+				} else {
+					log.Printf("failed to restore %s: %v\n", done.filename, rerr)
+				}
+			}
+			return fmt.Errorf("failed to write %s, the other files are restored: %v", f.filename, err)
+		}
+		f.tmp = ""
+	}
+	return nil
+}
+
+// writeTemp writes the content to a temporary file in the directory of filename
+func writeTemp(filename string, content []byte, mode os.FileMode) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".unexport")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), mode)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,49 @@
+package unexport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyTransaction(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod":     "module example.com/m\n\ngo 1.18\n",
+		"foo/foo.go": "package foo\n\nfunc F() {}\n\nfunc g() { F() }\n",
+		"foo/bar.go": "package foo\n\nfunc h() { F() }\n",
+	})
+	foo := filepath.Join(dir, "foo", "foo.go")
+	if err := os.Chmod(foo, 0600); err != nil {
+		t.Fatal(err)
+	}
+	u, err := Load(&Config{Dir: dir, Env: append(os.Environ(), "GOWORK=off", "GOFLAGS=")}, "./foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// renaming the declaration only fails to type-check, nothing is written
+	if err := u.apply(map[string]map[int]string{foo: {18: "f"}}); err == nil {
+		t.Errorf("expected a type error")
+	}
+	if content, _ := os.ReadFile(foo); string(content) != "package foo\n\nfunc F() {}\n\nfunc g() { F() }\n" {
+		t.Errorf("expected %s to be unchanged, got\n%s", foo, content)
+	}
+
+	if err := u.UpdateAll(); err != nil {
+		t.Fatal(err)
+	}
+	for filename, want := range map[string]string{
+		foo:                                 "package foo\n\nfunc f() {}\n\nfunc g() { f() }\n",
+		filepath.Join(dir, "foo", "bar.go"): "package foo\n\nfunc h() { f() }\n",
+	} {
+		if content, _ := os.ReadFile(filename); string(content) != want {
+			t.Errorf("expected %s to be\n%s, got\n%s", filename, want, content)
+		}
+	}
+	if fi, err := os.Stat(foo); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the permissions of %s to be kept, got %v", foo, fi.Mode())
+	}
+	// no temporary file is left
+	if entries, _ := os.ReadDir(filepath.Join(dir, "foo")); len(entries) != 2 {
+		t.Errorf("expected 2 files, got %v", entries)
+	}
+}
@@ -5,15 +5,18 @@ import (
 	"fmt"
 	"go/ast"
 	"go/build"
-	"go/format"
 	"go/parser"
 	"go/token"
 	"go/types"
 	"io/ioutil"
 	"log"
 	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/isaiah/unexport/lexical"
+	"golang.org/x/tools/go/buildutil"
 	"golang.org/x/tools/go/loader"
 	"golang.org/x/tools/refactor/importgraph"
 )
@@ -23,25 +26,41 @@ var (
 )
 
 func (u *Unexporter) unusedObjects() []types.Object {
-	if len(u.unexportableObjects) != 0 {
+	if len(u.unexportableObjects) != 0 || len(u.views) > 0 {
 		return u.unexportableObjects
 	}
 	used := u.usedObjects()
+	kept := u.keptObjects()
+	u.Stale = u.staleDirectives(used)
+	u.Kept = make(map[types.Object]string)
 	var objs []types.Object
-	for _, pkgInfo := range u.packages {
-		if pkgInfo.Pkg.Path() != u.path {
+	// the test variants of the packages are not considered, they are
+	// the same identifiers, see canonical
+	for path, pkgInfo := range u.iprog.Imported {
+		if !u.paths[path] || !u.project.includesPackage(path) {
 			continue
 		}
 		for id, obj := range pkgInfo.Defs {
 			if used[obj] {
 				continue
 			}
-			if id.IsExported() {
-				objs = append(objs, obj)
+			// identifiers declared in tests are not part of the package API
+			if !id.IsExported() || u.isTestFile(id.Pos()) {
+				continue
+			}
+			if !u.project.includesIdentifier(dottedName(u.Qualifier(obj)), objectKind(obj)) {
+				continue
+			}
+			if reason, ok := kept[obj]; ok {
+				u.Kept[obj] = reason
+				continue
+			}
+			if reason := u.keepRule(obj); reason != "" {
+				u.Kept[obj] = reason
+				continue
 			}
+			objs = append(objs, obj)
 		}
-		// No need to go further if path found
-		break
 	}
 	u.unexportableObjects = objs
 	return objs
@@ -56,15 +75,28 @@ func (u *Unexporter) usedObjects() map[types.Object]bool {
 			if obj.Pkg() == nil {
 				continue
 			}
-			// if it's a type from different package, store it
-			if obj.Pkg() != pkgInfo.Pkg {
-				objs[obj] = true
+			if u.testPolicy != TestUsesKeep && u.isTestFile(id.Pos()) {
+				if u.testPolicy == TestUsesReport && obj.Pkg().Path() != pkgInfo.Pkg.Path() {
+					u.testUses[u.canonical(obj)] = true
+				}
+				continue
+			}
+			if u.generatedPolicy == GeneratedUsesIgnore && u.isGenerated(id.Pos()) {
+				continue
+			}
+			if u.project.ignoresConsumer(pkgInfo.Pkg.Path()) {
+				continue
+			}
+			// if it's a type from different package, store it,
+			// the test variant of a package shares the path with the package
+			if obj.Pkg().Path() != pkgInfo.Pkg.Path() {
+				objs[u.canonical(obj)] = true
 			}
 			// embedded fields are marked as used, no much which package the original type belongs to,
 			// so that they won't show up in the renaming list #16
 			if field := pkgInfo.Defs[id]; field != nil {
 				// embdded field identifier is the same as it's type
-				objs[field] = true
+				objs[u.canonical(field)] = true
 			}
 		}
 	}
@@ -91,14 +123,16 @@ func (u *Unexporter) usedObjects() map[types.Object]bool {
 		for i := 0; i < lset.Len(); i++ {
 			obj := lset.At(i).Obj()
 			// LHS are the abstract methods, they are only exported if there are other packages using it
-			if lhs.Obj().Pkg() != rhs.Obj().Pkg() {
-				objs[obj] = true
+			// the predeclared error interface has no package
+			if lhs.Obj().Pkg() == nil || rhs.Obj().Pkg() == nil || lhs.Obj().Pkg().Path() != rhs.Obj().Pkg().Path() {
+				objs[u.canonical(obj)] = true
 			}
 			// if satisfied by type within the same package only, it should be unexported
 			// however, we should not rename from the concret method side, but from the
 			// interface side, carefully exclude concret methods that don't implement an abstract method (see #14, #17)
-			rsel := rset.Lookup(rhs.Obj().Pkg(), obj.Name())
-			objs[rsel.Obj()] = true
+			if rsel := rset.Lookup(rhs.Obj().Pkg(), obj.Name()); rsel != nil {
+				objs[u.canonical(rsel.Obj())] = true
+			}
 		}
 	}
 	return objs
@@ -136,34 +170,71 @@ func getDeclareStructOrInterface(prog *loader.Program, v *types.Var) string {
 	return ""
 }
 
-func loadProgram(ctx *build.Context, pkgs []string) (*loader.Program, error) {
+// loadProgram loads the packages, with tests the in-package test files are type-checked
+// together with the package, and the external test packages are created as well
+func loadProgram(c *Config, pkgs []string) (*loader.Program, error) {
 	conf := loader.Config{
-		Build:       ctx,
+		Build:       c.Context,
 		ParserMode:  parser.ParseComments,
-		AllowErrors: false,
+		AllowErrors: c.AllowErrors,
+	}
+	if c.AllowErrors {
+		// the errors are kept in PackageInfo.Errors, see Unexporter.Errors
+		conf.TypeChecker.Error = func(error) {}
 	}
 	for _, pkg := range pkgs {
-		conf.Import(pkg)
+		if c.Tests {
+			conf.ImportWithTests(pkg)
+		} else {
+			conf.Import(pkg)
+		}
 	}
 	return conf.Load()
 }
 
-// New creates a new Unexporter object that holds the states
-func New(ctx *build.Context, path string) (*Unexporter, error) {
-	pkgs := scanWorkspace(ctx, path)
-	prog, err := loadProgram(ctx, pkgs)
+// New creates a new Unexporter object that holds the states, paths are import paths
+// or patterns such as "foo/...", the matched packages are analyzed together
+func New(ctx *build.Context, paths ...string) (*Unexporter, error) {
+	return Load(&Config{Context: ctx}, paths...)
+}
 
-	if err != nil {
-		return nil, err
-	}
+// newUnexporter finds the unused identifiers of paths in the loaded program,
+// and checks the conflicts of unexporting each of them
+func newUnexporter(conf *Config, prog *loader.Program, paths []string) *Unexporter {
 	u := &Unexporter{
-		path:          path,
-		iprog:         prog,
-		packages:      make(map[*types.Package]*loader.PackageInfo),
-		warnings:      make(chan map[types.Object]string),
-		Identifiers:   make(map[types.Object]*ObjectInfo),
-		lexinfos:      make(map[*loader.PackageInfo]*lexical.Info),
-		changeMethods: true, // always true for unexporter
+		ctxt:            conf.Context,
+		journal:         conf.Journal,
+		testPolicy:      conf.TestPolicy,
+		interfaces:      conf.Interfaces,
+		generatedPolicy: conf.GeneratedPolicy,
+		project:         conf.Project,
+		keepRules:       conf.KeepRules,
+		namer:           conf.Namer,
+		paths:           make(map[string]bool),
+		iprog:           prog,
+		packages:        make(map[*types.Package]*loader.PackageInfo),
+		warnings:        make(chan map[types.Object][]Conflict),
+		Identifiers:     make(map[types.Object]*ObjectInfo),
+		lexinfos:        make(map[*loader.PackageInfo]*lexical.Info),
+		objIndexes:      make(map[*loader.PackageInfo]map[objKey]types.Object),
+		testUses:        make(map[types.Object]bool),
+		changeMethods:   true, // always true for unexporter
+	}
+
+	if conf.Project != nil && conf.Project.Interfaces != nil {
+		u.interfaces = conf.Project.Interfaces
+	}
+	if u.namer == nil {
+		u.namer = DefaultNamer
+	}
+	if u.keepRules == nil {
+		u.keepRules = DefaultKeepRules
+	}
+	if u.interfaces == nil {
+		u.interfaces = DefaultInterfaces
+	}
+	for _, path := range paths {
+		u.paths[path] = true
 	}
 
 	for _, info := range prog.Imported {
@@ -174,38 +245,41 @@ func New(ctx *build.Context, path string) (*Unexporter, error) {
 		u.packages[info.Pkg] = info
 	}
 
+	u.findGenerated()
 	unusedObjs := u.unusedObjects()
 	objs := make(chan map[types.Object]map[types.Object]string, 20)
 	input := make(chan types.Object, 20)
 	for _, obj := range unusedObjs {
-		u.Identifiers[obj] = &ObjectInfo{}
+		u.Identifiers[obj] = &ObjectInfo{
+			TestOnly: u.testUses[obj],
+			Unproven: u.brokenConsumers(obj.Pkg()),
+		}
 	}
 	go func() {
 		for _, obj := range unusedObjs {
 			input <- obj
 		}
+		close(input)
 	}()
-	// spawn the workers
+	// spawn the workers, they exit once every object is checked
 	for i := 0; i < 10; i++ {
 		go func() {
-			for {
-				select {
-				case obj := <-input:
-					toName := lowerFirst(obj.Name())
-					objsToUpdate := make(map[types.Object]string)
-					u.check(objsToUpdate, obj, toName)
-					objs <- map[types.Object]map[types.Object]string{obj: objsToUpdate}
-				}
+			for obj := range input {
+				toName := u.proposedName(obj)
+				objsToUpdate := make(map[types.Object]string)
+				u.check(objsToUpdate, obj, toName)
+				objs <- map[types.Object]map[types.Object]string{obj: objsToUpdate}
 			}
 		}()
 	}
+	// the conflicts are reported for the object being checked, which may be
+	// an object renamed along with the identifier, e.g. a coupled method
+	conflicts := make(map[types.Object][]Conflict)
 	for i := 0; i < len(unusedObjs); {
 		select {
 		case m := <-u.warnings:
-			for obj, warning := range m {
-				if u.Identifiers[obj] != nil {
-					u.Identifiers[obj].Warning = warning
-				}
+			for obj, cs := range m {
+				conflicts[obj] = append(conflicts[obj], cs...)
 			}
 		case m := <-objs:
 			for obj, objsToUpdate := range m {
@@ -218,45 +292,106 @@ DONE:
 	for {
 		select {
 		case m := <-u.warnings:
-			for obj, warning := range m {
-				u.Identifiers[obj].Warning = warning
+			for obj, cs := range m {
+				conflicts[obj] = append(conflicts[obj], cs...)
 			}
 		default:
 			break DONE
 		}
 	}
-	return u, nil
+	for _, info := range u.Identifiers {
+		var cs []Conflict
+		for obj := range info.objsToUpdate {
+			cs = append(cs, conflicts[obj]...)
+		}
+		info.setConflicts(cs)
+	}
+	return u
+}
+
+// proposedName returns the new name of obj, given by the naming strategy
+// unless the project configuration overrides it
+func (u *Unexporter) proposedName(obj types.Object) string {
+	path := obj.Pkg().Path()
+	if to, ok := u.project.newName(path, strings.TrimPrefix(dottedName(u.Qualifier(obj)), path+".")); ok {
+		return to
+	}
+	return u.namer.Unexport(obj)
 }
 
 // Update unexport the specified identifier
 func (u *Unexporter) Update(obj types.Object) error {
-	return u.update(u.Identifiers[obj].objsToUpdate)
+	return u.apply(u.renamesOf(obj), obj)
 }
 
-// UpdateAll apply all renaming, conflicts are ignored
+// UpdateAll applies the renaming of every identifier at once. Nothing is
+// written, and the error is returned, if an identifier causes conflicts or is
+// not proven safe, see Check, if the renamed packages fail to type-check, or
+// if a file fails to be written.
 func (u *Unexporter) UpdateAll() error {
+	var objs []types.Object
+	var unsafe []string
+	for obj, info := range u.Identifiers {
+		objs = append(objs, obj)
+		if len(info.Conflicts) > 0 || info.Warning != "" || len(info.Unproven) > 0 {
+			unsafe = append(unsafe, u.Qualifier(obj))
+		}
+	}
+	if len(unsafe) > 0 {
+		sort.Strings(unsafe)
+		return fmt.Errorf("no file is written, the renaming of %s causes conflicts or is not proven safe", strings.Join(unsafe, ", "))
+	}
+	return u.apply(u.renamesOf(objs...), objs...)
+}
+
+// renamesOf returns the renamings of the identifiers, as Check last checked
+// them, by file name and offset, see renames
+func (u *Unexporter) renamesOf(objs ...types.Object) map[string]map[int]string {
+	if len(u.views) > 0 {
+		return u.matrixRenames(objs...)
+	}
 	objsToUpdate := make(map[types.Object]string)
-	for _, objInfo := range u.Identifiers {
-		for obj, to := range objInfo.objsToUpdate {
-			objsToUpdate[obj] = to
+	for _, obj := range objs {
+		if info := u.Identifiers[obj]; info != nil {
+			for obj, to := range info.objsToUpdate {
+				objsToUpdate[obj] = to
+			}
 		}
 	}
-	return u.update(objsToUpdate)
+	return u.renames(objsToUpdate)
 }
 
 // Check checks if any possible renaming conflict and return the conflict information
 func (u *Unexporter) Check(from types.Object, to string) string {
+	if len(u.views) > 0 {
+		return u.matrixCheck(from, to)
+	}
 	objsToUpdate := make(map[types.Object]string)
-	u.check(objsToUpdate, from, to)
-	close(u.warnings)
-	u.Identifiers[from] = &ObjectInfo{objsToUpdate: objsToUpdate}
-	for ws := range u.warnings {
-		for _, warning := range ws {
-			u.Identifiers[from].Warning = warning
-			return warning
+	done := make(chan bool)
+	go func() {
+		u.check(objsToUpdate, from, to)
+		close(done)
+	}()
+	var conflicts []Conflict
+	for checking := true; checking; {
+		select {
+		case m := <-u.warnings:
+			for _, cs := range m {
+				conflicts = append(conflicts, cs...)
+			}
+		case <-done:
+			// the warnings are sent synchronously, none is left
+			checking = false
 		}
 	}
-	return ""
+	info := u.Identifiers[from]
+	if info == nil {
+		info = &ObjectInfo{}
+		u.Identifiers[from] = info
+	}
+	info.objsToUpdate = objsToUpdate
+	info.setConflicts(conflicts)
+	return info.Warning
 }
 
 // sort the objects, see #8
@@ -282,65 +417,164 @@ func (u *Unexporter) UnusedObjectsSorted() []types.Object {
 
 // Qualifier the full qualifier for specified object, ready for consumption of `gorename` command
 func (u *Unexporter) Qualifier(obj types.Object) string {
-	return wholePath(obj, u.path, u.iprog)
+	if v := u.owners[obj]; v != nil {
+		return v.Qualifier(obj)
+	}
+	return wholePath(obj, obj.Pkg().Path(), u.iprog)
 }
 
 // This is copy & pasted from x/tools/refactor/rename
-// update renames the identifiers, updates the input files.
-func (u *Unexporter) update(objsToUpdate map[types.Object]string) error {
-	// We use token.File, not filename, since a file may appear to
-	// belong to multiple packages and be parsed more than once.
-	// token.File captures this distinction; filename does not.
-	var nidents int
-	var filesToUpdate = make(map[*token.File]bool)
+// renames finds the identifiers that refer to objsToUpdate, and returns their
+// new names by file name and offset.
+func (u *Unexporter) renames(objsToUpdate map[types.Object]string) map[string]map[int]string {
+	renames := make(map[string]map[int]string)
+	add := func(id *ast.Ident, obj types.Object) {
+		// The test variants of a package share the syntax trees with the
+		// package, an identifier is found once per variant.
+		if to, ok := objsToUpdate[u.canonical(obj)]; ok {
+			pos := u.iprog.Fset.PositionFor(id.Pos(), false)
+			if renames[pos.Filename] == nil {
+				renames[pos.Filename] = make(map[int]string)
+			}
+			renames[pos.Filename][pos.Offset] = to
+		}
+	}
 	for _, info := range u.packages {
-		// Mutate the ASTs and note the filenames.
 		for id, obj := range info.Defs {
-			if to, ok := objsToUpdate[obj]; ok {
-				nidents++
-				id.Name = to
-				filesToUpdate[u.iprog.Fset.File(id.Pos())] = true
+			if obj != nil {
+				add(id, obj)
 			}
 		}
 		for id, obj := range info.Uses {
-			if to, ok := objsToUpdate[obj]; ok {
-				nidents++
-				id.Name = to
-				filesToUpdate[u.iprog.Fset.File(id.Pos())] = true
-			}
+			add(id, obj)
 		}
 	}
+	return renames
+}
 
-	// TODO(adonovan): don't rewrite cgo + generated files.
-	var nerrs, npkgs int
-	for _, info := range u.packages {
-		first := true
-		for _, f := range info.Files {
-			tokenFile := u.iprog.Fset.File(f.Pos())
-			if filesToUpdate[tokenFile] {
-				if first {
-					npkgs++
-					first = false
+// apply renames the identifiers, as returned by renames, and rewrites the files.
+// Only the renamed identifiers are replaced in the original content of the
+// files, the formatting and the comments are left as they are. With a build
+// matrix, a file is rewritten once, by the first configuration that includes
+// it. The rewriting is a transaction: the new contents are staged, the
+// affected packages are type-checked again in every configuration, and the
+// files are replaced at once, or none of them. The renaming of objs is then
+// recorded in the journal, if any.
+func (u *Unexporter) apply(renames map[string]map[int]string, objs ...types.Object) error {
+	t, err := u.stage(renames)
+	if err != nil {
+		return err
+	}
+	if err := writeFiles(t.files); err != nil {
+		t.rollback()
+		return err
+	}
+	var op Operation
+	if u.journal != "" {
+		op = u.operation(t, objs, u.written)
+	}
+	if u.written == nil {
+		u.written = make(map[string]map[int]string)
+	}
+	for filename, edits := range t.edits {
+		u.written[filename] = edits
+	}
+	npkgs := len(t.pkgs)
+	log.Printf("Renamed %d occurrence%s in %d file%s in %d package%s.\n",
+		t.nidents, plural(t.nidents),
+		len(t.files), plural(len(t.files)),
+		npkgs, plural(npkgs))
+	if u.journal != "" && len(t.files) > 0 {
+		if err := appendJournal(u.journal, op); err != nil {
+			return fmt.Errorf("the files are renamed, but the renaming is not recorded in the journal: %v", err)
+		}
+	}
+	return nil
+}
+
+// stage renames the identifiers in the syntax trees, computes the new
+// contents of the files and verifies them, nothing is written
+func (u *Unexporter) stage(renames map[string]map[int]string) (*transaction, error) {
+	views := u.views
+	if len(views) == 0 {
+		views = []*Unexporter{u}
+	}
+	// TODO(adonovan): don't rewrite cgo files.
+	t := &transaction{
+		files:   make(map[string][]byte),
+		edits:   make(map[string]map[int]string),
+		pkgs:    make(map[string]bool),
+		renamed: make(map[*ast.Ident]string),
+	}
+	for _, v := range views {
+		for _, info := range v.packages {
+			for _, f := range info.Files {
+				tokenFile := v.iprog.Fset.File(f.Pos())
+				offsets := renames[tokenFile.Name()]
+				if len(offsets) == 0 {
+					continue
+				}
+				if v.generated[tokenFile.Name()] {
+					// reported as a conflict, see checkGenerated
+					log.Printf("Skipping the generated file %s\n", tokenFile.Name())
+					continue
+				}
+				if !t.pkgs[info.Pkg.Path()] {
+					t.pkgs[info.Pkg.Path()] = true
 					if Verbose {
 						log.Printf("Updating package %s\n",
 							info.Pkg.Path())
 					}
 				}
-				if err := rewriteFile(u.iprog.Fset, f, tokenFile.Name()); err != nil {
-					log.Printf("gorename: %s\n", err)
-					nerrs++
+				// Mutate the AST of every view, the file is staged once.
+				_, staged := t.files[tokenFile.Name()]
+				ast.Inspect(f, func(n ast.Node) bool {
+					if id, ok := n.(*ast.Ident); ok {
+						if to, ok := offsets[tokenFile.Offset(id.Pos())]; ok {
+							if !staged {
+								t.nidents++
+							}
+							if _, ok := t.renamed[id]; !ok {
+								t.renamed[id] = id.Name
+							}
+							id.Name = to
+						}
+					}
+					return true
+				})
+				if staged {
+					continue
+				}
+				src, err := u.source(v, tokenFile)
+				if err != nil {
+					t.rollback()
+					return nil, err
+				}
+				// the renamings of the previous updates are applied again
+				edits := make(map[int]string)
+				for offset, to := range u.written[tokenFile.Name()] {
+					edits[offset] = to
 				}
+				for offset, to := range offsets {
+					edits[offset] = to
+				}
+				content, err := applyEdits(src, edits)
+				if err != nil {
+					t.rollback()
+					return nil, fmt.Errorf("%s: %v", tokenFile.Name(), err)
+				}
+				t.files[tokenFile.Name()] = content
+				t.edits[tokenFile.Name()] = edits
 			}
 		}
 	}
-	log.Printf("Renamed %d occurrence%s in %d file%s in %d package%s.\n",
-		nidents, plural(nidents),
-		len(filesToUpdate), plural(len(filesToUpdate)),
-		npkgs, plural(npkgs))
-	if nerrs > 0 {
-		return fmt.Errorf("failed to rewrite %d file%s", nerrs, plural(nerrs))
+	for _, v := range views {
+		if err := v.verify(t.files); err != nil {
+			t.rollback()
+			return nil, err
+		}
 	}
-	return nil
+	return t, nil
 }
 
 func plural(n int) string {
@@ -350,20 +584,137 @@ func plural(n int) string {
 	return ""
 }
 
-var rewriteFile = func(fset *token.FileSet, f *ast.File, filename string) (err error) {
-	// TODO(adonovan): print packages and filenames in a form useful
-	// to editors (so they can reload files).
-	if Verbose {
-		log.Printf("\t%s\n", filename)
+// source returns the content of the file as it was loaded, the renamings
+// are applied to it, so the rest of the file is left as it is
+func (u *Unexporter) source(v *Unexporter, tokenFile *token.File) ([]byte, error) {
+	if src, ok := u.sources[tokenFile.Name()]; ok {
+		return src, nil
+	}
+	var src []byte
+	var err error
+	if v.ctxt != nil {
+		src, err = readFile(v.ctxt, tokenFile.Name())
+	} else {
+		src, err = ioutil.ReadFile(tokenFile.Name())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(src) != tokenFile.Size() {
+		return nil, fmt.Errorf("%s has changed since it was loaded", tokenFile.Name())
+	}
+	if u.sources == nil {
+		u.sources = make(map[string][]byte)
+	}
+	u.sources[tokenFile.Name()] = src
+	return src, nil
+}
+
+// readFile reads a file of the build context
+func readFile(ctxt *build.Context, filename string) ([]byte, error) {
+	f, err := buildutil.OpenFile(ctxt, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// applyEdits replaces the identifiers of src starting at the offsets
+func applyEdits(src []byte, edits map[int]string) ([]byte, error) {
+	var offsets []int
+	for offset := range edits {
+		offsets = append(offsets, offset)
 	}
+	sort.Ints(offsets)
 	var buf bytes.Buffer
-	if err := format.Node(&buf, fset, f); err != nil {
-		return fmt.Errorf("failed to pretty-print syntax tree: %v", err)
+	last := 0
+	for _, offset := range offsets {
+		if offset < last || offset >= len(src) {
+			return nil, fmt.Errorf("invalid offset %d", offset)
+		}
+		end := identEnd(src, offset)
+		if end == offset {
+			return nil, fmt.Errorf("no identifier at offset %d", offset)
+		}
+		buf.Write(src[last:offset])
+		buf.WriteString(edits[offset])
+		last = end
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), nil
+}
+
+// identEnd returns the end of the identifier of src starting at offset
+func identEnd(src []byte, offset int) int {
+	end := offset
+	for end < len(src) {
+		r, size := utf8.DecodeRune(src[end:])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		end += size
 	}
-	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+	return end
+}
+
+// expandPatterns expands the "..." patterns into the matching import paths
+func expandPatterns(ctxt *build.Context, patterns []string) []string {
+	var paths []string
+	for path := range buildutil.ExpandPatterns(ctxt, patterns) {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// brokenConsumers returns the packages that failed to type-check, and import
+// pkg or are pkg itself, the uses of pkg in them may be missing
+func (u *Unexporter) brokenConsumers(pkg *types.Package) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for p, info := range u.packages {
+		if len(info.Errors) == 0 || seen[p.Path()] {
+			continue
+		}
+		consumer := p.Path() == pkg.Path()
+		for _, imp := range p.Imports() {
+			consumer = consumer || imp.Path() == pkg.Path()
+		}
+		if consumer {
+			seen[p.Path()] = true
+			paths = append(paths, p.Path())
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Errors returns the errors of the packages that failed to type-check, by import
+// path, they are only tolerated with Config.AllowErrors
+func (u *Unexporter) Errors() map[string][]error {
+	errs := make(map[string][]error)
+	for _, v := range u.views {
+		for path, es := range v.Errors() {
+			if errs[path] == nil {
+				errs[path] = es
+			}
+		}
+	}
+	for p, info := range u.packages {
+		if len(info.Errors) > 0 && errs[p.Path()] == nil {
+			errs[p.Path()] = info.Errors
+		}
+	}
+	return errs
+}
+
+// isTestFile reports whether pos is in a _test.go file
+func (u *Unexporter) isTestFile(pos token.Pos) bool {
+	return strings.HasSuffix(u.iprog.Fset.Position(pos).Filename, "_test.go")
 }
 
-func scanWorkspace(ctxt *build.Context, path string) []string {
+func scanWorkspace(ctxt *build.Context, paths ...string) []string {
 	// Scan the workspace and build the import graph.
 	_, rev, errors := importgraph.Build(ctxt)
 	if len(errors) > 0 {
@@ -377,9 +728,11 @@ func scanWorkspace(ctxt *build.Context, path string) []string {
 
 	// Enumerate the set of potentially affected packages.
 	var affectedPackages []string
-	// External test packages are never imported,
-	// so they will never appear in the graph.
-	for pkg := range rev.Search(path) {
+	// External test packages are never imported, so they will never
+	// appear in the graph, but the imports of _test.go files are part of
+	// the graph, the test packages are loaded along with their package,
+	// see loadProgram.
+	for pkg := range rev.Search(paths...) {
 		affectedPackages = append(affectedPackages, pkg)
 	}
 	return affectedPackages
@@ -1,12 +1,17 @@
 package unexport
 
 import (
+	"bytes"
 	"fmt"
 	"go/build"
 	"go/types"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/tools/go/buildutil"
 	"golang.org/x/tools/go/loader"
@@ -37,7 +42,7 @@ var _ foo.I = s(0)
 			pkg: "bar",
 		},
 	} {
-		prog, err := loadProgram(test.ctx, []string{test.pkg})
+		prog, err := loadProgram(&Config{Context: test.ctx}, []string{test.pkg})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -348,6 +353,15 @@ return y.F()
 		}),
 			pkg: "foo",
 		},
+		// method implementing the predeclared error interface
+		{ctx: main(`package main
+type E struct{}
+func (E) Error() string { return "" }
+var _ error = E{}
+`),
+			pkg:  "main",
+			want: map[string]string{"\"main\".E": "e"},
+		},
 	} {
 		// test body
 		unexporter, err := New(test.ctx, test.pkg)
@@ -432,8 +446,319 @@ F() int
 	}
 }
 
+func TestMultiplePackages(t *testing.T) {
+	ctxt := fakeContext(map[string][]string{
+		"foo": {`
+package foo
+type S int
+type T int
+`},
+		"bar": {`
+package bar
+import "foo"
+func F(t *foo.T) {}
+var V int
+`},
+		"baz": {`
+package baz
+import "bar"
+var _ = bar.F
+`},
+	})
+	u, err := New(ctxt, "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, o := range u.UnusedObjectsSorted() {
+		got = append(got, u.Qualifier(o))
+	}
+	sort.Strings(got)
+	want := []string{`"bar".V`, `"foo".S`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestExternalTests(t *testing.T) {
+	ctxt := buildutil.FakeContext(map[string]map[string]string{
+		"foo": {
+			"0.go": `package foo; func F() {}; func G() {}`,
+			"0_test.go": `package foo_test
+import "foo"
+func ExampleF() { foo.F() }
+`,
+		},
+	})
+	for _, test := range []struct {
+		tests bool
+		want  []string
+	}{
+		{tests: false, want: []string{"F", "G"}},
+		{tests: true, want: []string{"G"}},
+	} {
+		u, err := Load(&Config{Context: ctxt, Tests: test.tests}, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, o := range u.UnusedObjectsSorted() {
+			got = append(got, o.Name())
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("expected %v, got %v", test.want, got)
+		}
+	}
+}
+
+func TestBuildMatrix(t *testing.T) {
+	ctxt := buildutil.FakeContext(map[string]map[string]string{
+		"foo": {
+			"0.go": `package foo; func F() {}; func G() {}`,
+		},
+		"bar": {
+			"0.go":         `package bar`,
+			"1_windows.go": `package bar; import "foo"; var _ = foo.F`,
+			"2.go": `//go:build integration
+
+package bar; import "foo"; var _ = foo.G`,
+		},
+	})
+	for _, test := range []struct {
+		matrix []string
+		want   []string
+	}{
+		{matrix: []string{"linux/amd64"}, want: []string{"F", "G"}},
+		{matrix: []string{"linux/amd64", "windows/amd64"}, want: []string{"G"}},
+		{matrix: []string{"linux/amd64", ":integration"}, want: []string{"F"}},
+		{matrix: []string{"linux/amd64", "windows/amd64", "linux/amd64:integration"}},
+	} {
+		conf := &Config{Context: ctxt}
+		for _, s := range test.matrix {
+			bc, err := ParseBuildConfig(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf.Matrix = append(conf.Matrix, bc)
+		}
+		u, err := Load(conf, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, o := range u.UnusedObjectsSorted() {
+			got = append(got, o.Name())
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: expected %v, got %v", test.matrix, test.want, got)
+		}
+	}
+}
+
+func TestBuildMatrixUpdate(t *testing.T) {
+	ctxt := buildutil.FakeContext(map[string]map[string]string{
+		"foo": {
+			"0.go":         `package foo; func G() {}`,
+			"1_windows.go": `package foo; func h() { G() }`,
+		},
+	})
+	conf := &Config{Context: ctxt, Matrix: []BuildConfig{{GOOS: "linux", GOARCH: "amd64"}, {GOOS: "windows", GOARCH: "amd64"}}}
+	u, err := Load(conf, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := captureRewrites(t)
+	if err := u.UpdateAll(); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"0.go", "1_windows.go"} {
+		if !strings.Contains(got[filepath.Join("/go/src/foo", file)], "g()") {
+			t.Errorf("%s is not renamed: %q", file, got)
+		}
+	}
+}
+
+func TestBuildMatrixUpdateSequence(t *testing.T) {
+	ctxt := buildutil.FakeContext(map[string]map[string]string{
+		"foo": {
+			"a.go": `package foo; var A int`,
+			"b.go": `package foo; var B = A`,
+		},
+	})
+	conf := &Config{Context: ctxt, Matrix: []BuildConfig{{GOOS: "linux", GOARCH: "amd64"}, {GOOS: "windows", GOARCH: "amd64"}}}
+	u, err := Load(conf, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := captureRewrites(t)
+	objs := make(map[string]types.Object)
+	for _, o := range u.UnusedObjectsSorted() {
+		objs[o.Name()] = o
+	}
+	// every configuration sees the renaming of A when B is verified
+	for _, name := range []string{"A", "B"} {
+		if err := u.Update(objs[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "package foo; var b = a"; got["/go/src/foo/b.go"] != want {
+		t.Errorf("expected %q, got %q", want, got["/go/src/foo/b.go"])
+	}
+}
+
+func TestUpdateAllConflicts(t *testing.T) {
+	ctxt := fakeContext(map[string][]string{
+		"foo": {"package foo\n\nfunc F() {}\n\nfunc G() { f := func() {}; f(); F() }\n"},
+	})
+	u, err := New(ctxt, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := captureRewrites(t)
+	// G is free of conflicts, but nothing is written
+	if err := u.UpdateAll(); err == nil || !strings.Contains(err.Error(), `"foo".F causes conflicts`) {
+		t.Errorf("expected the conflicts of F, got %v", err)
+	}
+	if len(got) > 0 {
+		t.Errorf("expected no file to be written, got %v", got)
+	}
+}
+
+func TestUpdateKeepsFormatting(t *testing.T) {
+	ctxt := fakeContext(map[string][]string{
+		"foo": {"package foo\n\n// F   is  exported\nfunc F( )  {}   // F\n\nvar  V=1\n\nfunc g() {F( ); _ = V}\n"},
+	})
+	u, err := New(ctxt, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := captureRewrites(t)
+	for _, o := range u.UnusedObjectsSorted() {
+		// one identifier at a time, as in the interactive mode
+		if err := u.Update(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "package foo\n\n// F   is  exported\nfunc f( )  {}   // F\n\nvar  v=1\n\nfunc g() {f( ); _ = v}\n"
+	if got["/go/src/foo/0.go"] != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got["/go/src/foo/0.go"])
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ctxt := fakeContext(map[string][]string{
+		"foo": {
+			"package foo\n\nfunc F() {}\n\n// 1\n// 2\n// 3\n// 4\n// 5\n// 6\n// 7\n// 8\n\nfunc g() { F() }",
+			"package foo\n\nfunc h() { F() }\n",
+		},
+	})
+	u, err := New(ctxt, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := captureRewrites(t)
+	var buf bytes.Buffer
+	if err := u.Diff(&buf, "/go/src", u.UnusedObjectsSorted()...); err != nil {
+		t.Fatal(err)
+	}
+	want := `--- a/foo/0.go
++++ b/foo/0.go
+@@ -1,6 +1,6 @@
+ package foo
+ 
+-func F() {}
++func f() {}
+ 
+ // 1
+ // 2
+@@ -11,4 +11,4 @@
+ // 7
+ // 8
+ 
+-func g() { F() }
+\ No newline at end of file
++func g() { f() }
+\ No newline at end of file
+--- a/foo/1.go
++++ b/foo/1.go
+@@ -1,3 +1,3 @@
+ package foo
+ 
+-func h() { F() }
++func h() { f() }
+`
+	if buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf.String())
+	}
+	if len(got) != 0 {
+		t.Errorf("expected no file to be written, got %v", got)
+	}
+}
+
+func TestWorkersExit(t *testing.T) {
+	ctxt := fakeContext(map[string][]string{
+		"foo": {`package foo; func F() {}; func G() {}; type T struct{ X int }`},
+	})
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		if _, err := New(ctxt, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the workers exit once the last result is received
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("expected %d goroutines, got %d", before, n)
+	}
+}
+
+func TestAllowErrors(t *testing.T) {
+	ctxt := fakeContext(map[string][]string{
+		"foo": {`package foo; func F() {}; func G() {}`},
+		"bar": {`package bar; import "foo"; var _ = foo.F; var _ int = "broken"; func h() { undefinedChan <- 1 }`},
+		"baz": {`package baz; import "foo"; var _ = foo.F`},
+	})
+	if _, err := Load(&Config{Context: ctxt}, "foo"); err == nil {
+		t.Errorf("expected the type error of bar")
+	}
+	u, err := Load(&Config{Context: ctxt, AllowErrors: true}, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Identifiers) != 1 {
+		t.Fatalf("expected G only, got %v", u.Identifiers)
+	}
+	for obj, info := range u.Identifiers {
+		if obj.Name() != "G" || !reflect.DeepEqual(info.Unproven, []string{"bar"}) {
+			t.Errorf("expected G to be unproven because of bar, got %s %v", obj.Name(), info.Unproven)
+		}
+	}
+	if errs := u.Errors(); len(errs) != 1 || len(errs["bar"]) == 0 {
+		t.Errorf("expected the errors of bar, got %v", errs)
+	}
+}
+
 // ---------------------------------------------------------------------
 
+// captureRewrites replaces writeFiles for the duration of the test,
+// the returned map is filled with the content of the rewritten files
+func captureRewrites(t *testing.T) map[string]string {
+	files := make(map[string]string)
+	orig := writeFiles
+	writeFiles = func(staged map[string][]byte) error {
+		for filename, content := range staged {
+			files[filename] = string(content)
+		}
+		return nil
+	}
+	t.Cleanup(func() { writeFiles = orig })
+	return files
+}
+
 // Simplifying wrapper around buildutil.FakeContext for packages whose
 // filenames are sequentially numbered (%d.go).  pkgs maps a package
 // import path to its list of file contents.
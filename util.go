@@ -3,8 +3,6 @@ package unexport
 import (
 	"fmt"
 	"go/types"
-	"unicode"
-	"unicode/utf8"
 
 	"golang.org/x/tools/go/loader"
 )
@@ -21,14 +19,6 @@ func wholePath(obj types.Object, path string, prog *loader.Program) string {
 	return fmt.Sprintf("\"%s\".%s", path, obj.Name())
 }
 
-func lowerFirst(s string) string {
-	if s == "" {
-		return ""
-	}
-	r, n := utf8.DecodeRuneInString(s)
-	return string(unicode.ToLower(r)) + s[n:]
-}
-
 func typeName(t types.Type) string {
 	switch p := t.(type) {
 	case *types.Pointer: